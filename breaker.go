@@ -0,0 +1,125 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gsql
+
+import (
+	"github.com/BlueStorm001/gsql/datatable"
+	"sync"
+	"time"
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota //requests flow normally
+	BreakerOpen                         //requests fail fast with ErrCircuitOpen
+	BreakerHalfOpen                     //a single probe request is let through
+)
+
+// breaker 熔断器 连续失败threshold次后打开 coolDown后放行一个探测请求
+// breaker opens after threshold consecutive connection failures and lets a
+// single probe through once coolDown has passed
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	coolDown  time.Duration
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+}
+
+// Breaker 启用熔断 连接连续失败threshold次后 coolDown内直接返回ErrCircuitOpen
+// Breaker enables a circuit breaker: after threshold consecutive connection
+// failures, requests fail fast with ErrCircuitOpen until coolDown has passed
+// and a single probe succeeds
+func (s *Serve) Breaker(threshold int, coolDown time.Duration) *Serve {
+	if threshold <= 0 {
+		s.breaker = nil
+		return s
+	}
+	s.breaker = &breaker{threshold: threshold, coolDown: coolDown}
+	return s
+}
+
+// BreakerState 熔断器当前状态
+// BreakerState returns the state of the circuit breaker, BreakerClosed if none is set
+func (s *Serve) BreakerState() BreakerState {
+	b := s.breaker
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.coolDown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		return true
+	case BreakerHalfOpen:
+		return false //probe in flight
+	default:
+		return true
+	}
+}
+
+func (b *breaker) done(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// guard 经熔断器连接主库并执行fn 连接失败以connectError返回
+// guard connects to the primary through the circuit breaker and runs fn;
+// a failed connect is returned as a connectError
+func (s *Serve) guard(fn func() error) error {
+	b := s.breaker
+	if b != nil && !b.allow() {
+		return ErrCircuitOpen
+	}
+	if err := s.ISQL.Connect(); err != nil {
+		if b != nil {
+			b.done(true)
+		}
+		return &connectError{err}
+	}
+	err := fn()
+	if b != nil {
+		b.done(datatable.IsConnError(err))
+	}
+	return err
+}
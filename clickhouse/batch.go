@@ -0,0 +1,275 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"github.com/BlueStorm001/gsql/datatable"
+	"github.com/BlueStorm001/gsql/util"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrWriterClosed 向已关闭的BatchWriter写入
+// ErrWriterClosed is returned by writes to a closed BatchWriter
+var ErrWriterClosed = errors.New("clickhouse: batch writer is closed")
+
+// BatchOptions BatchWriter的参数 零值使用默认值
+// BatchOptions tune a BatchWriter; zero fields take the defaults
+type BatchOptions struct {
+	Rows       int                       //flush once this many rows are buffered, default 10000
+	Interval   time.Duration             //flush buffered rows at least this often, default 1s
+	Buffer     int                       //rows queued for the next flushes before Write blocks, default 2*Rows
+	Attempts   int                       //attempts of a flush failing with Retryable errors before its rows are dropped, default 3
+	RetryDelay time.Duration             //delay before the first retry, doubled for each further one, default 100ms
+	OnError    func(rows int, err error) //called with the rows dropped by a failed flush
+}
+
+// BatchWriter 缓存单行写入 达到行数或时间阈值时在一个事务中批量插入
+// BatchWriter buffers single rows for a table and inserts them in large
+// batches, each through one prepared statement in one transaction, once
+// Rows rows are buffered or Interval has passed. Write blocks while the
+// buffer is full; Close flushes what is left.
+type BatchWriter struct {
+	serve   *Serve
+	table   string
+	options BatchOptions
+	rows    chan map[string]*datatable.Field
+	done    chan struct{}
+	mu      sync.RWMutex
+	closed  bool
+	errMu   sync.Mutex //apart from mu, which blocked writers hold while run flushes
+	err     error      //last failed flush
+	colMu   sync.Mutex
+	columns string //sorted field names of the first row, every row must match them
+}
+
+// NewBatchWriter 创建并启动table的BatchWriter 使用后需调用Close
+// NewBatchWriter starts a BatchWriter for table; call Close when done with it
+func NewBatchWriter(serve *Serve, table string, options BatchOptions) (*BatchWriter, error) {
+	if serve == nil {
+		return nil, errors.New("clickhouse: batch writer needs a serve")
+	}
+	if table == "" || util.Verify(table) {
+		return nil, errors.New("clickhouse: invalid table name " + table)
+	}
+	if options.Rows <= 0 {
+		options.Rows = 10000
+	}
+	if options.Interval <= 0 {
+		options.Interval = time.Second
+	}
+	if options.Buffer <= 0 {
+		options.Buffer = 2 * options.Rows
+	}
+	if options.Attempts <= 0 {
+		options.Attempts = 3
+	}
+	if options.RetryDelay <= 0 {
+		options.RetryDelay = 100 * time.Millisecond
+	}
+	w := &BatchWriter{
+		serve:   serve,
+		table:   table,
+		options: options,
+		rows:    make(chan map[string]*datatable.Field, options.Buffer),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Write 缓存一行(结构体 结构体指针或map[string]interface{}) 缓冲区满时阻塞
+// Write buffers a row, given as a struct, a pointer to one or a
+// map[string]interface{}, blocking while the buffer is full
+func (w *BatchWriter) Write(row interface{}) error {
+	return w.WriteContext(context.Background(), row)
+}
+
+// WriteContext 与Write相同 缓冲区满时可由ctx取消等待 字段与第一行不同的行返回错误
+// WriteContext is like Write but stops waiting for buffer space when ctx is
+// done. A row whose fields differ from those of the first row is rejected,
+// as one batch is inserted with the columns of its first row.
+func (w *BatchWriter) WriteContext(ctx context.Context, row interface{}) error {
+	fields, err := structFields(row)
+	if err != nil {
+		return err
+	}
+	if err = w.checkColumns(fields); err != nil {
+		return err
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrWriterClosed
+	}
+	select {
+	case w.rows <- fields:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// checkColumns 行的字段须与第一行相同
+// checkColumns makes sure a row has the same fields as the first row written
+func (w *BatchWriter) checkColumns(fields map[string]*datatable.Field) error {
+	names := make([]string, 0, len(fields))
+	for k := range fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	columns := strings.Join(names, ",")
+	w.colMu.Lock()
+	defer w.colMu.Unlock()
+	if w.columns == "" {
+		w.columns = columns
+	}
+	if columns != w.columns {
+		return errors.New("clickhouse: batch row fields " + columns + " differ from " + w.columns)
+	}
+	return nil
+}
+
+// Close 停止接收写入 等待缓存的行全部插入 返回最后一次失败的错误
+// Close stops accepting rows, waits until every buffered row is flushed and
+// returns the error of the last flush that failed
+func (w *BatchWriter) Close() error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.rows)
+	}
+	w.mu.Unlock()
+	<-w.done
+	return w.Err()
+}
+
+// Err 最后一次失败的错误
+// Err returns the error of the last flush that failed
+func (w *BatchWriter) Err() error {
+	w.errMu.Lock()
+	defer w.errMu.Unlock()
+	return w.err
+}
+
+func (w *BatchWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.options.Interval)
+	defer ticker.Stop()
+	batch := make([]map[string]*datatable.Field, 0, w.options.Rows)
+	for {
+		select {
+		case row, ok := <-w.rows:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			if batch = append(batch, row); len(batch) < w.options.Rows {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		w.flush(batch)
+		batch = make([]map[string]*datatable.Field, 0, w.options.Rows)
+	}
+}
+
+// flush 插入一批 暂时性错误退避重试 其它错误或重试用尽后丢弃并通知OnError
+// flush inserts a batch, backing off between attempts while the error is
+// Retryable, and drops it reporting to OnError on any other error or once
+// the attempts are used up
+func (w *BatchWriter) flush(batch []map[string]*datatable.Field) {
+	if len(batch) == 0 {
+		return
+	}
+	var err error
+	delay := w.options.RetryDelay
+	for attempt := 1; ; attempt++ {
+		if err = w.insert(batch); err == nil {
+			return
+		}
+		if attempt >= w.options.Attempts || !w.serve.Retryable(err) {
+			break
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+	w.errMu.Lock()
+	w.err = err
+	w.errMu.Unlock()
+	if w.options.OnError != nil {
+		w.options.OnError(len(batch), err)
+	}
+}
+
+func (w *BatchWriter) insert(batch []map[string]*datatable.Field) error {
+	orm := &datatable.ORM{
+		SqlCommand:   util.NewBuilder(),
+		SqlStructMap: batch[0],
+		SqlBatch:     batch,
+		TableName:    w.table,
+		Mode:         datatable.Add,
+	}
+	if err := w.serve.Insert(orm); err != nil {
+		return err
+	}
+	_, err := w.serve.Execute(orm)
+	return err
+}
+
+// structFields 行的字段 map的值原样使用 结构体取导出字段 sql标签转为小写
+// structFields returns the fields of a row. Map values are taken as they are,
+// without gsql.GetStruct's []string and []interface{} value-and-tag forms;
+// structs contribute their exported fields, with lower-cased sql tags, and
+// unexported fields are skipped rather than rejected.
+func structFields(row interface{}) (map[string]*datatable.Field, error) {
+	if m, ok := row.(map[string]interface{}); ok {
+		fields := make(map[string]*datatable.Field, len(m))
+		for k, v := range m {
+			fields[k] = &datatable.Field{Val: v}
+		}
+		return fields, nil
+	}
+	rv := reflect.ValueOf(row)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, errors.New("clickhouse: batch rows must be structs")
+	}
+	rt := rv.Type()
+	fields := make(map[string]*datatable.Field, rt.NumField())
+	for i := 0; i < rt.NumField(); i++ {
+		if rt.Field(i).PkgPath != "" {
+			continue
+		}
+		fields[rt.Field(i).Name] = &datatable.Field{Tag: strings.ToLower(rt.Field(i).Tag.Get("sql")), Val: rv.Field(i).Interface()}
+	}
+	return fields, nil
+}
@@ -21,65 +21,142 @@
 package clickhouse
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"github.com/BlueStorm001/gsql/datatable"
 	"github.com/BlueStorm001/gsql/util"
+	"net"
+	"net/url"
+	"strconv"
 	"strings"
-	"time"
 )
 
 type Serve struct {
 	*datatable.Serve
-	conn *sql.DB
+	*datatable.Conn
 }
 
-func (s *Serve) Connect() error {
-	var err error
-	if s == nil || s.conn == nil {
-		err = errors.New("conn is null")
-	} else {
-		err = s.conn.Ping()
-	}
-	if err == nil {
-		return nil
+// New 创建方言 连接由Serve的字段或驱动函数打开
+// New creates the dialect for serve, connecting as serve's fields or drive function say
+func New(serve *datatable.Serve) *Serve {
+	s := &Serve{Serve: serve}
+	s.Conn = datatable.NewConn(serve, "clickhouse", s.DSN, nil)
+	return s
+}
+
+// DSN 由Host Port Auth Database Options生成clickhouse-go连接串 Settings作为服务端设置传递
+// DSN builds a clickhouse-go data source name from Host, Port, Auth, Database
+// and Options; Options.Settings are passed on as server settings
+func (s *Serve) DSN() string {
+	port := s.Port
+	if port == 0 {
+		port = 9000
 	}
-	switch s.DriveMode {
-	case 1:
-		s.conn, s.Error = s.DriveServe(s.Serve)
-	case 2:
-		s.conn, s.Error = s.Drive()
+	params := url.Values{}
+	if s.Auth != nil {
+		params.Set("username", s.Auth.User)
+		params.Set("password", s.Auth.Pass)
+	}
+	if s.Database != "" {
+		params.Set("database", s.Database)
+	}
+	if s.Options.ReadTimeout > 0 {
+		params.Set("read_timeout", strconv.FormatFloat(s.Options.ReadTimeout.Seconds(), 'f', -1, 64))
+	}
+	if s.Options.WriteTimeout > 0 {
+		params.Set("write_timeout", strconv.FormatFloat(s.Options.WriteTimeout.Seconds(), 'f', -1, 64))
+	}
+	if s.Options.Compress {
+		params.Set("compress", "true")
+	}
+	switch s.Options.TLS {
+	case "", "false":
+	case "skip-verify":
+		params.Set("secure", "true")
+		params.Set("skip_verify", "true")
 	default:
-		s.Error = errors.New("drive mode error")
+		params.Set("secure", "true")
+	}
+	for k, v := range s.Options.Settings {
+		params.Set(k, v)
 	}
-	if s.Error != nil {
-		return s.Error
+	dsn := "tcp://" + net.JoinHostPort(s.Host, strconv.Itoa(port))
+	if len(params) > 0 {
+		dsn += "?" + params.Encode()
 	}
-	return s.conn.Ping()
+	return dsn
 }
 
-func (s *Serve) Close() error {
-	var err error
-	if s.conn != nil {
-		if err = s.conn.Close(); err == nil {
-			s.conn = nil
+// Retryable 同时查询过多(202) 超时(159,209) 网络错误(210) 连接断开
+// Retryable reports too many simultaneous queries (202), timeouts (159, 209) and network errors (210)
+func (s *Serve) Retryable(err error) bool {
+	if datatable.IsConnError(err) {
+		return true
+	}
+	if strings.Contains(strings.ToLower(err.Error()), "too many simultaneous queries") {
+		return true
+	}
+	if code, ok := datatable.ErrorNumber(err); ok {
+		switch code {
+		case 202, 159, 209, 210:
+			return true
 		}
 	}
-	return err
+	return false
+}
+
+// Classify ClickHouse 没有唯一约束和死锁
+// Classify returns nil; ClickHouse has neither unique constraints nor deadlocks
+func (s *Serve) Classify(err error) error {
+	return nil
+}
+
+// Placeholder 第n个参数的占位符
+// Placeholder returns the placeholder for the n-th argument, counting from 1
+func (s *Serve) Placeholder(n int) string {
+	return "?"
+}
+
+// bind 追加参数并返回占位符 ALTER TABLE的UPDATE/DELETE改写为字面量 无法表示的值返回错误
+// bind appends value to the arguments and returns its placeholder; the
+// ALTER TABLE mutations behind Update and Delete get the value as a literal,
+// failing for values that have no literal form
+func (s *Serve) bind(orm *datatable.ORM, value interface{}) (string, error) {
+	if orm.Mode == datatable.Set || orm.Mode == datatable.Del {
+		return updateValue(value)
+	}
+	orm.SqlValues = append(orm.SqlValues, value)
+	return s.Placeholder(len(orm.SqlValues)), nil
 }
 
 func (s *Serve) query(command string, args ...interface{}) (*sql.Rows, error) {
-	if err := s.Connect(); err != nil {
+	conn, err := s.DB()
+	if err != nil {
 		return nil, err
 	}
-	return s.conn.Query(command, args...)
+	rows, err := conn.Query(command, args...)
+	if err != nil {
+		s.Fail(err)
+	}
+	return rows, err
 }
 
 func (s *Serve) exec(command string, args ...interface{}) (sql.Result, error) {
-	if err := s.Connect(); err != nil {
+	return s.execContext(context.Background(), command, args...)
+}
+
+func (s *Serve) execContext(ctx context.Context, command string, args ...interface{}) (sql.Result, error) {
+	conn, err := s.DB()
+	if err != nil {
 		return nil, err
 	}
-	return s.conn.Exec(command, args...)
+	res, err := conn.ExecContext(ctx, command, args...)
+	if err != nil {
+		s.Fail(err)
+	}
+	return res, err
 }
 
 func (s *Serve) dataTable(command string, params ...interface{}) (*datatable.DataTable, error) {
@@ -108,7 +185,7 @@ func (s *Serve) Select(orm *datatable.ORM) error {
 	var use bool
 	switch orm.ColumnMode {
 	case 1:
-		for c := range orm.Columns {
+		for _, c := range orm.ColumnNames() {
 			if use {
 				orm.SqlCommand.Append(",")
 			}
@@ -116,7 +193,7 @@ func (s *Serve) Select(orm *datatable.ORM) error {
 			use = true
 		}
 	default:
-		for c := range orm.SqlStructMap {
+		for _, c := range orm.FieldNames() {
 			if orm.ColumnMode == -1 {
 				if util.WhetherToSkip(orm.ColumnMode, orm.Columns, c) {
 					continue
@@ -140,28 +217,40 @@ func (s *Serve) Count(orm *datatable.ORM) error {
 }
 
 func (s *Serve) Insert(orm *datatable.ORM) error {
+	if orm.Conflict != nil {
+		return errors.New("clickhouse does not support upsert")
+	}
 	orm.SqlCommand.Reset()
 	orm.SqlCommand.Append(" INSERT INTO ").Append(orm.TableName)
-	fieldStr := "("
-	valueStr := "("
-	for k, v := range orm.SqlStructMap {
-		if v.Tag != "" && strings.Contains(v.Tag, "auto_increment") {
+	var fields []string
+	for _, k := range orm.FieldNames() {
+		if orm.Generated(k) {
 			continue
 		}
 		if util.WhetherToSkip(orm.ColumnMode, orm.Columns, k) {
 			continue
 		}
-		if len(fieldStr) > 1 {
-			fieldStr += ","
-			valueStr += ","
+		fields = append(fields, k)
+	}
+	orm.SqlCommand.Append("(").Append(strings.Join(fields, ",")).Append(")")
+	orm.SqlCommand.Append("VALUES(")
+	//clickhouse-go prepares one row and executes it once per row, see insert
+	for i, row := range orm.Rows() {
+		for j, k := range fields {
+			placeholder, err := s.bind(orm, row[k].Value())
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				continue
+			}
+			if j > 0 {
+				orm.SqlCommand.Append(",")
+			}
+			orm.SqlCommand.Append(placeholder)
 		}
-		fieldStr += k
-		valueStr += "@" + k
-		orm.SqlValues = append(orm.SqlValues, sql.Named(k, v.Val))
 	}
-	fieldStr += ")"
-	valueStr += ")"
-	orm.SqlCommand.Append(fieldStr).Append("VALUES").Append(valueStr)
+	orm.SqlCommand.Append(")")
 	return nil
 }
 
@@ -169,15 +258,19 @@ func (s *Serve) Update(orm *datatable.ORM) error {
 	orm.SqlCommand.Reset()
 	orm.SqlCommand.Append(" ALTER TABLE ").Append(orm.TableName).Append(" UPDATE ")
 	var use bool
-	for k, v := range orm.SqlStructMap {
+	for _, k := range orm.FieldNames() {
+		v := orm.SqlStructMap[k]
 		if util.WhetherToSkip(orm.ColumnMode, orm.Columns, k) {
 			continue
 		}
 		if use {
 			orm.SqlCommand.Append(",")
 		}
-		orm.SqlCommand.Append(k).Append("=").Append(updateValue(v.Val))
-		//orm.SqlValues = append(orm.SqlValues, v.Val)
+		value, err := s.bind(orm, v.Val)
+		if err != nil {
+			return err
+		}
+		orm.SqlCommand.Append(k).Append("=").Append(value)
 		use = true
 	}
 	return nil
@@ -196,17 +289,19 @@ func (s *Serve) Where(orm *datatable.ORM, wheres ...string) error {
 	orm.SqlCommand.Append(" WHERE")
 	for i, w := range wheres {
 		if util.Verify(w) {
-			return errors.New("verification failed")
+			return datatable.ErrVerification
 		}
 		field, andor := util.GetFieldName(w)
 		if v, ok := orm.SqlStructMap[field]; ok {
-			if orm.Mode == datatable.Set {
-				w = strings.Replace(w, "?", updateValue(v.Val), 1)
-			} else {
-				orm.SqlValues = append(orm.SqlValues, v.Val)
+			if strings.Contains(w, "?") {
+				value, err := s.bind(orm, v.Val)
+				if err != nil {
+					return err
+				}
+				w = strings.Replace(w, "?", value, 1)
 			}
 		} else {
-			return errors.New("the query condition does not exist")
+			return datatable.ErrUnknownCondition
 		}
 		if i > 0 {
 			switch andor {
@@ -231,14 +326,28 @@ func (s *Serve) GroupBy(orm *datatable.ORM, field string) error {
 }
 
 func (s *Serve) Limit(orm *datatable.ORM, limit int, offset ...int) error {
+	orm.SqlCommand.Append(" LIMIT ")
 	if len(offset) > 0 {
-		orm.SqlCommand.Append(" LIMIT ").AppendInt(offset[0]).Append(",").AppendInt(limit)
-	} else {
-		orm.SqlCommand.Append(" LIMIT ").AppendInt(limit)
+		value, err := s.bind(orm, offset[0])
+		if err != nil {
+			return err
+		}
+		orm.SqlCommand.Append(value).Append(",")
 	}
+	value, err := s.bind(orm, limit)
+	if err != nil {
+		return err
+	}
+	orm.SqlCommand.Append(value)
 	return nil
 }
 
+// Call ClickHouse没有存储过程
+// Call fails: ClickHouse has no stored procedures
+func (s *Serve) Call(orm *datatable.ORM, proc string, params ...sql.NamedArg) error {
+	return errors.New("clickhouse does not support stored procedures")
+}
+
 func (s *Serve) DataSet(orm *datatable.ORM) (*datatable.DataSet, error) {
 	return s.dataSet(orm.SqlCommand.String(), orm.SqlValues...)
 }
@@ -247,77 +356,55 @@ func (s *Serve) DataTable(orm *datatable.ORM) (*datatable.DataTable, error) {
 	return s.dataTable(orm.SqlCommand.String(), orm.SqlValues...)
 }
 
-func (s *Serve) insert(command string, args ...interface{}) (sql.Result, error) {
-	if s.conn == nil {
-		if err := s.Connect(); err != nil {
-			return nil, err
-		}
+// insert 在一个事务中逐行执行预处理的Insert 每行width个参数
+// insert runs the prepared single-row insert once for every width arguments in one transaction
+func (s *Serve) insert(command string, width int, args ...interface{}) (sql.Result, error) {
+	conn, err := s.DB()
+	if err != nil {
+		return nil, err
 	}
 	var tx *sql.Tx
 	var stmt *sql.Stmt
 	var res sql.Result
-	var err error
-	if tx, err = s.conn.Begin(); err != nil {
+	if tx, err = conn.Begin(); err != nil {
+		s.Fail(err)
 		return nil, err
 	}
 	if stmt, err = tx.Prepare(command); err != nil {
+		_ = tx.Rollback()
 		return nil, err
 	}
 	defer stmt.Close()
-	if res, err = stmt.Exec(args...); err != nil {
-		return nil, err
+	if width <= 0 {
+		width = len(args)
+	}
+	var affected int64
+	for i := 0; ; i += width {
+		if res, err = stmt.Exec(args[i : i+width]...); err != nil {
+			_ = tx.Rollback()
+			s.Fail(err)
+			return nil, err
+		}
+		if n, e := res.RowsAffected(); e == nil {
+			affected += n
+		}
+		if i+width >= len(args) {
+			break
+		}
 	}
 	if err = tx.Commit(); err != nil {
 		return nil, err
 	}
-	return res, nil
+	return driver.RowsAffected(affected), nil
 }
 
 func (s *Serve) Execute(orm *datatable.ORM) (sql.Result, error) {
 	switch orm.Mode {
 	case datatable.Add:
-		return s.insert(orm.SqlCommand.String(), orm.SqlValues...)
+		return s.insert(orm.SqlCommand.String(), len(orm.SqlValues)/len(orm.Rows()), orm.SqlValues...)
+	case datatable.Set, datatable.Del:
+		return s.mutate(orm)
 	default:
 		return s.exec(orm.SqlCommand.String(), orm.SqlValues...)
 	}
 }
-
-func convertSQL(v string) string {
-	v = strings.Replace(v, `\`, `\\`, -1)
-	v = strings.Replace(v, "'", "\\'", -1)
-	return v
-}
-
-func updateValue(value interface{}) string {
-	switch v := value.(type) {
-	case float32:
-		return "toFloat32(" + util.ToString(v) + ")"
-	case float64:
-		return "toFloat64(" + util.ToString(v) + ")"
-	case int, int8, int16, int32, uint, uint8, uint16, uint32:
-		return util.ToString(v)
-	case int64:
-		return "toInt64(" + util.ToString(v) + ")"
-	case uint64:
-		return "toUInt64(" + util.ToString(v) + ")"
-	case string:
-		return "'" + convertSQL(v) + "'"
-	case bool:
-		if v {
-			return "1"
-		}
-		return "0"
-	case nil:
-		return "null"
-	case []byte:
-		vv := util.BytToStr(v)
-		if util.Verify(vv) {
-			return "xxx"
-		}
-		return vv
-	case time.Time:
-		return "toDateTime('" + util.ToDateTimeStr(v) + "')"
-	default:
-		return "''"
-	}
-}
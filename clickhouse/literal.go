@@ -0,0 +1,249 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package clickhouse
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Date 以Date32字面量写入的日期
+// Date is a calendar date, rendered as a Date32 literal
+type Date time.Time
+
+// Decimal 以字符串表示的十进制数 保持精度 如Decimal("12.345")
+// Decimal is a decimal number kept as text for exact precision, such as Decimal("12.345")
+type Decimal string
+
+// Tuple 以Tuple字面量写入的值 (切片写为Array)
+// Tuple is rendered as a Tuple literal, where a plain slice would be an Array
+type Tuple []interface{}
+
+var valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+
+// updateValue 将值渲染为ClickHouse字面量 用于ALTER TABLE的UPDATE/DELETE
+// nil和空指针为NULL 切片为Array map为Map 结构体和Tuple为Tuple 无法表示的类型返回错误
+// updateValue renders value as a ClickHouse literal for the ALTER TABLE
+// mutations. nil and nil pointers are NULL (Nullable), slices are Arrays,
+// maps are Maps, structs and Tuple are Tuples, [16]byte is a UUID, and
+// driver.Valuer values are rendered from their Value. Other types fail.
+func updateValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "NULL", nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case int, int8, int16, int32, uint, uint8, uint16, uint32:
+		return fmt.Sprint(v), nil
+	case int64:
+		return "toInt64(" + strconv.FormatInt(v, 10) + ")", nil
+	case uint64:
+		return "toUInt64(" + strconv.FormatUint(v, 10) + ")", nil
+	case float32:
+		return "toFloat32(" + float(float64(v), 32) + ")", nil
+	case float64:
+		return "toFloat64(" + float(v, 64) + ")", nil
+	case string:
+		return quote([]byte(v), false), nil
+	case []byte:
+		return quote(v, true), nil
+	case time.Time:
+		return dateTime(v), nil
+	case Date:
+		return "toDate32('" + time.Time(v).Format("2006-01-02") + "')", nil
+	case Decimal:
+		return decimal(string(v))
+	case *big.Float:
+		if v == nil {
+			return "NULL", nil
+		}
+		return decimal(v.Text('f', -1))
+	case *big.Int:
+		if v == nil {
+			return "NULL", nil
+		}
+		return decimal(v.String())
+	case Tuple:
+		return list("tuple(", []interface{}(v), ")")
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Array && rv.Len() == 16 && rv.Type().Elem().Kind() == reflect.Uint8 {
+		b := make([]byte, 16)
+		reflect.Copy(reflect.ValueOf(b), rv)
+		return fmt.Sprintf("toUUID('%x-%x-%x-%x-%x')", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+	}
+	if rv.Type().Implements(valuerType) {
+		if rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return "NULL", nil
+		}
+		v, err := value.(driver.Valuer).Value()
+		if err != nil {
+			return "", err
+		}
+		return updateValue(v)
+	}
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return "NULL", nil
+		}
+		return updateValue(rv.Elem().Interface())
+	case reflect.Bool:
+		return updateValue(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return updateValue(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return updateValue(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return updateValue(rv.Float())
+	case reflect.String:
+		return updateValue(rv.String())
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return "[]", nil
+		}
+		items := make([]interface{}, rv.Len())
+		for i := range items {
+			items[i] = rv.Index(i).Interface()
+		}
+		return list("[", items, "]")
+	case reflect.Map:
+		return mapValue(rv)
+	case reflect.Struct:
+		var items []interface{}
+		for i := 0; i < rv.NumField(); i++ {
+			if rv.Type().Field(i).PkgPath == "" {
+				items = append(items, rv.Field(i).Interface())
+			}
+		}
+		return list("tuple(", items, ")")
+	}
+	return "", fmt.Errorf("clickhouse: no literal for %T", value)
+}
+
+// quote 单引号字符串 转义反斜杠 引号和控制字符 raw为真时非ASCII字节写为\xHH
+// quote renders a quoted string, escaping backslashes, quotes and control
+// characters; with raw every non-ASCII byte is written as \xHH
+func quote(b []byte, raw bool) string {
+	var sb strings.Builder
+	sb.WriteByte('\'')
+	for _, c := range b {
+		switch {
+		case c == '\\' || c == '\'':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c == '\r':
+			sb.WriteString(`\r`)
+		case c == '\t':
+			sb.WriteString(`\t`)
+		case c < 0x20 || c == 0x7f || (raw && c >= 0x80):
+			sb.WriteString(fmt.Sprintf(`\x%02X`, c))
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('\'')
+	return sb.String()
+}
+
+func float(v float64, bits int) string {
+	switch {
+	case math.IsNaN(v):
+		return "nan"
+	case math.IsInf(v, 1):
+		return "inf"
+	case math.IsInf(v, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, bits)
+}
+
+// dateTime 以UTC写入 有小数秒时使用DateTime64(9)
+// dateTime renders the instant in UTC, as a DateTime64(9) when it has fractional seconds
+func dateTime(t time.Time) string {
+	t = t.UTC()
+	if t.Nanosecond() == 0 {
+		return "toDateTime('" + t.Format("2006-01-02 15:04:05") + "', 'UTC')"
+	}
+	return "toDateTime64('" + t.Format("2006-01-02 15:04:05.000000000") + "', 9, 'UTC')"
+}
+
+// decimal 十进制字面量 精度取小数位数
+// decimal renders a Decimal128 literal whose scale is the number of fractional digits
+func decimal(text string) (string, error) {
+	if _, ok := new(big.Float).SetString(text); !ok || strings.ContainsAny(text, "eEnN") {
+		return "", fmt.Errorf("clickhouse: invalid decimal %q", text)
+	}
+	scale := 0
+	if i := strings.IndexByte(text, '.'); i >= 0 {
+		scale = len(text) - i - 1
+	}
+	return "toDecimal128('" + text + "', " + strconv.Itoa(scale) + ")", nil
+}
+
+func list(open string, items []interface{}, end string) (string, error) {
+	parts := make([]string, len(items))
+	for i, item := range items {
+		v, err := updateValue(item)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = v
+	}
+	return open + strings.Join(parts, ", ") + end, nil
+}
+
+// mapValue Map字面量 按键排序保证结果稳定
+// mapValue renders a Map literal, with the keys sorted so the output is stable
+func mapValue(rv reflect.Value) (string, error) {
+	type entry struct{ k, v string }
+	entries := make([]entry, 0, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		k, err := updateValue(iter.Key().Interface())
+		if err != nil {
+			return "", err
+		}
+		v, err := updateValue(iter.Value().Interface())
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, entry{k, v})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].k < entries[j].k })
+	parts := make([]string, 0, 2*len(entries))
+	for _, e := range entries {
+		parts = append(parts, e.k, e.v)
+	}
+	return "map(" + strings.Join(parts, ", ") + ")", nil
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package clickhouse
+
+import (
+	"math"
+	"math/big"
+	"testing"
+	"time"
+)
+
+type point struct {
+	X, Y int
+	name string
+}
+
+func TestUpdateValue(t *testing.T) {
+	id := 7
+	var none *int
+	at := time.Date(2021, 3, 4, 5, 6, 7, 0, time.FixedZone("CST", 8*3600))
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{nil, "NULL"},
+		{none, "NULL"},
+		{&id, "7"},
+		{true, "1"},
+		{int64(-3), "toInt64(-3)"},
+		{uint64(3), "toUInt64(3)"},
+		{1.5, "toFloat64(1.5)"},
+		{math.NaN(), "toFloat64(nan)"},
+		{float32(math.Inf(-1)), "toFloat32(-inf)"},
+		{"it's \\ ok\n", `'it\'s \\ ok\n'`},
+		{[]byte{'a', 0, 0xff}, `'a\x00\xFF'`},
+		{at, "toDateTime('2021-03-03 21:06:07', 'UTC')"},
+		{at.Add(1500 * time.Microsecond), "toDateTime64('2021-03-03 21:06:07.001500000', 9, 'UTC')"},
+		{Date(at), "toDate32('2021-03-04')"},
+		{Decimal("-12.340"), "toDecimal128('-12.340', 3)"},
+		{big.NewInt(5), "toDecimal128('5', 0)"},
+		{[16]byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 1, 2, 3, 4, 5, 6, 7, 8}, "toUUID('12345678-9abc-def0-0102-030405060708')"},
+		{[]string{"a", "b"}, "['a', 'b']"},
+		{[]int{}, "[]"},
+		{map[string]int{"b": 2, "a": 1}, "map('a', 1, 'b', 2)"},
+		{Tuple{1, "x", nil}, "tuple(1, 'x', NULL)"},
+		{point{X: 1, Y: 2}, "tuple(1, 2)"},
+	}
+	for _, test := range tests {
+		got, err := updateValue(test.value)
+		if err != nil || got != test.want {
+			t.Errorf("updateValue(%#v) = %s, %v; want %s", test.value, got, err, test.want)
+		}
+	}
+	for _, value := range []interface{}{make(chan int), Decimal("1e5"), []interface{}{func() {}}} {
+		if _, err := updateValue(value); err == nil {
+			t.Errorf("updateValue(%T) should fail", value)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/BlueStorm001/gsql/datatable"
+	"github.com/BlueStorm001/gsql/util"
+	"strings"
+	"time"
+)
+
+// ErrMutationTimeout 变更未在Serve的超时时间内完成 变更仍在服务端继续执行
+// ErrMutationTimeout is returned when a mutation is still running after the
+// Serve's timeout; the server carries on with it
+var ErrMutationTimeout = errors.New("clickhouse: mutation did not finish within the timeout")
+
+// MutationError 变更失败 Reason为system.mutations的latest_fail_reason
+// MutationError is a failed mutation, Reason being its latest_fail_reason in system.mutations
+type MutationError struct {
+	Table  string
+	ID     string
+	Reason string
+}
+
+func (e *MutationError) Error() string {
+	return "clickhouse: mutation " + e.ID + " on " + e.Table + " failed: " + e.Reason
+}
+
+const (
+	pollMin = 50 * time.Millisecond
+	pollMax = time.Second
+)
+
+// mutate 执行ALTER TABLE UPDATE/DELETE 按orm.Wait等待变更完成
+// mutate runs an ALTER TABLE UPDATE/DELETE and waits for the mutation as orm.Wait asks
+func (s *Serve) mutate(orm *datatable.ORM) (sql.Result, error) {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if s.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(s.Timeout)*time.Second)
+	}
+	defer cancel()
+	switch orm.Wait {
+	case datatable.MutationSync:
+		res, err := s.execContext(ctx, orm.SqlCommand.String()+" SETTINGS mutations_sync=2", orm.SqlValues...)
+		if err != nil && ctx.Err() != nil {
+			return nil, ErrMutationTimeout
+		}
+		return res, err
+	case datatable.MutationPoll:
+		earlier, err := s.pendingMutations(orm.TableName)
+		if err != nil {
+			return nil, err
+		}
+		res, err := s.exec(orm.SqlCommand.String(), orm.SqlValues...)
+		if err != nil {
+			return nil, err
+		}
+		return res, s.waitMutation(ctx, orm.TableName, earlier)
+	}
+	return s.exec(orm.SqlCommand.String(), orm.SqlValues...)
+}
+
+// pendingMutations 表上未完成的变更 按创建时间排序
+// pendingMutations returns the unfinished mutations of table, oldest first
+func (s *Serve) pendingMutations(table string) ([]map[string]interface{}, error) {
+	database := "currentDatabase()"
+	args := []interface{}{table}
+	if i := strings.LastIndexByte(table, '.'); i >= 0 {
+		database = "?"
+		args = []interface{}{table[:i], table[i+1:]}
+	}
+	dt, err := s.dataTable("SELECT mutation_id, latest_fail_reason FROM system.mutations WHERE database="+database+
+		" AND table=? AND is_done=0 ORDER BY create_time", args...)
+	if err != nil {
+		return nil, err
+	}
+	return dt.Rows, nil
+}
+
+// waitMutation 轮询system.mutations 直到ALTER之后创建的变更全部完成 earlier为ALTER之前未完成的变更 不等待也不报告
+// waitMutation polls system.mutations until every mutation created after the
+// ALTER has finished. The earlier ones, unfinished before the ALTER, are
+// neither waited for nor reported; one that failed still blocks ours, which
+// then runs into the timeout.
+func (s *Serve) waitMutation(ctx context.Context, table string, earlier []map[string]interface{}) error {
+	seen := make(map[string]bool, len(earlier))
+	for _, row := range earlier {
+		seen[util.ToString(row["mutation_id"])] = true
+	}
+	for delay := pollMin; ; delay *= 2 {
+		rows, err := s.pendingMutations(table)
+		if err != nil {
+			return err
+		}
+		var pending bool
+		for _, row := range rows {
+			id := util.ToString(row["mutation_id"])
+			if seen[id] {
+				continue
+			}
+			if reason := util.ToString(row["latest_fail_reason"]); reason != "" {
+				return &MutationError{Table: table, ID: id, Reason: reason}
+			}
+			pending = true
+		}
+		if !pending {
+			return nil
+		}
+		if delay > pollMax {
+			delay = pollMax
+		}
+		select {
+		case <-ctx.Done():
+			return ErrMutationTimeout
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package datatable
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+)
+
+// Conn 方言共用的连接 按DriveMode打开 连接断开后只做检查 由database/sql替换坏连接 不更换连接池
+// Conn is the connection a dialect embeds. It opens the *sql.DB on first use
+// as DriveMode says; after a connection error the pool is pinged before its
+// next use but kept, since database/sql replaces the bad connections itself
+// and other goroutines may still be using it.
+type Conn struct {
+	serve  *Serve
+	driver string                    //default database/sql driver name for DriveMode 0
+	dsn    func() string             //data source name for DriveMode 0
+	limit  func(conn *sql.DB, n int) //pool limits, nil sets both open and idle to n
+	mu     sync.Mutex
+	conn   *sql.DB
+	broken bool //conn failed, verify before next use
+}
+
+// NewConn 创建方言的连接 driver和dsn用于DriveMode 0 limit为nil时最大打开和空闲连接数均为n
+// NewConn creates the connection of a dialect: driver and dsn open it in
+// DriveMode 0, and limit, if not nil, applies ConnectMax to the pool
+func NewConn(serve *Serve, driver string, dsn func() string, limit func(conn *sql.DB, n int)) *Conn {
+	if limit == nil {
+		limit = func(conn *sql.DB, n int) {
+			if n > 0 {
+				conn.SetMaxOpenConns(n)
+				conn.SetMaxIdleConns(n)
+			}
+		}
+	}
+	return &Conn{serve: serve, driver: driver, dsn: dsn, limit: limit}
+}
+
+func (c *Conn) Connect() error {
+	_, err := c.DB()
+	return err
+}
+
+// DB 返回当前连接 首次使用时打开 连接断开后先检查
+// DB returns the connection, opening it on first use and pinging it first after it was found broken
+func (c *Conn) DB() (*sql.DB, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		if !c.broken {
+			return c.conn, nil
+		}
+		if err := c.conn.Ping(); err != nil {
+			return nil, err
+		}
+		c.broken = false
+		return c.conn, nil
+	}
+	var conn *sql.DB
+	var err error
+	s := c.serve
+	switch s.DriveMode {
+	case 0:
+		driver := s.Options.Driver
+		if driver == "" {
+			driver = c.driver
+		}
+		conn, err = sql.Open(driver, c.dsn())
+	case 1:
+		conn, err = s.DriveServe(s)
+	case 2:
+		conn, err = s.Drive()
+	default:
+		err = errors.New("drive mode error")
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.limit(conn, s.ConnectMax)
+	//keep the pool even if the first ping fails, the drive may share it
+	c.conn = conn
+	if err = conn.Ping(); err != nil {
+		c.broken = true
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Ping 检查连接 失败时标记连接断开 下次使用前先检查
+// Ping checks the connection, marking it broken on failure so the next use pings it first
+func (c *Conn) Ping() error {
+	conn, err := c.DB()
+	if err != nil {
+		return err
+	}
+	if err = conn.Ping(); err != nil {
+		c.MarkBroken()
+	}
+	return err
+}
+
+// Fail 连接类错误标记连接断开
+// Fail marks the connection broken when err is a connection error
+func (c *Conn) Fail(err error) {
+	if IsConnError(err) {
+		c.MarkBroken()
+	}
+}
+
+// MarkBroken 标记连接断开 下次使用前先检查
+// MarkBroken makes the next use ping the connection first
+func (c *Conn) MarkBroken() {
+	c.mu.Lock()
+	c.broken = true
+	c.mu.Unlock()
+}
+
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	if c.conn != nil {
+		if err = c.conn.Close(); err == nil {
+			c.conn = nil
+		}
+	}
+	return err
+}
+
+func (c *Conn) Stats() sql.DBStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return sql.DBStats{}
+	}
+	return c.conn.Stats()
+}
+
+func (c *Conn) SetMaxConns(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.limit(c.conn, n)
+	}
+}
@@ -22,9 +22,11 @@ package datatable
 
 import (
 	"database/sql"
-	"errors"
 	"github.com/BlueStorm001/gsql/util"
+	"reflect"
+	"sort"
 	"strings"
+	"time"
 )
 
 type ISQL interface {
@@ -39,9 +41,16 @@ type ISQL interface {
 	OrderBy(orm *ORM, field string) error
 	GroupBy(orm *ORM, field string) error
 	Limit(orm *ORM, limit int, offset ...int) error
+	Call(orm *ORM, proc string, params ...sql.NamedArg) error
 	Execute(orm *ORM) (sql.Result, error)
 	Connect() error
+	Ping() error
 	Close() error
+	Stats() sql.DBStats
+	SetMaxConns(n int)
+	Retryable(err error) bool
+	Classify(err error) error
+	Placeholder(n int) string
 }
 
 type Auth struct {
@@ -56,6 +65,9 @@ type SqlResult struct {
 	//数据集合
 	DataTable *DataTable
 
+	//存储过程的全部结果集 Every result set of a stored procedure, see Serve.Call
+	DataSet *DataSet
+
 	// LastInsertId returns the integer generated by the database
 	// in response to a command. Typically this will be from an
 	// "auto increment" column when inserting a new row. Not all
@@ -67,6 +79,24 @@ type SqlResult struct {
 	// update, insert, or delete. Not every database or database
 	// driver may support this.
 	RowsAffected int64 //影响的行数
+
+	//批量插入的全部新id Ids of every row of an insert, when the dialect returns them
+	InsertIds []int64
+}
+
+// Options 内置DSN的连接参数 未使用的参数由方言忽略
+// Options tune the DSN a dialect builds from Host, Port, Auth and Database
+// when no drive function is set; dialects ignore the fields they do not support
+type Options struct {
+	Driver       string            //database/sql driver name, defaults to the dialect's usual driver
+	Charset      string            //mysql
+	TLS          string            //mysql tls, mssql encrypt, clickhouse secure ("skip-verify" skips verification)
+	ReadTimeout  time.Duration     //mysql, clickhouse
+	WriteTimeout time.Duration     //mysql, clickhouse
+	ParseTime    bool              //mysql
+	Compress     bool              //clickhouse
+	Settings     map[string]string //extra DSN parameters, e.g. clickhouse settings
+	Version      int               //mssql major version, below 11 (2012) Limit uses TOP/ROW_NUMBER()
 }
 
 type Serve struct {
@@ -75,6 +105,7 @@ type Serve struct {
 	Port     int
 	Database string
 	Auth     *Auth
+	Options  Options
 	//最大连接数
 	ConnectMax int //Maximum number of connections
 	//超时(秒)
@@ -82,7 +113,7 @@ type Serve struct {
 	Error      error
 	DriveServe func(s *Serve) (db *sql.DB, err error) //mode 1
 	Drive      func() (db *sql.DB, err error)         // mode 2
-	DriveMode  int
+	DriveMode  int                                    // 0 builds the DSN from the fields above
 }
 
 type findKind uint
@@ -104,6 +135,15 @@ type Field struct {
 	Val interface{}
 }
 
+// Value 字段值 字段不存在时为nil
+// Value returns the field's value, or nil for a missing field
+func (f *Field) Value() interface{} {
+	if f == nil {
+		return nil
+	}
+	return f.Val
+}
+
 type DataTable struct {
 	Name    string
 	Columns []*Column
@@ -114,6 +154,7 @@ type DataTable struct {
 
 type DataSet struct {
 	Tables []*DataTable
+	Status int //存储过程返回值(mssql) Return status of a stored procedure (mssql)
 }
 
 type UseMode int
@@ -125,39 +166,142 @@ const (
 	Set
 	Del
 	Count
+	Exec //raw statement from Serve.Exec
+	Call //stored procedure from Serve.Call
+)
+
+// MutationWait ClickHouse的ALTER TABLE UPDATE/DELETE是否等待变更完成
+// MutationWait selects whether ClickHouse ALTER TABLE UPDATE/DELETE wait for the mutation to finish
+type MutationWait int
+
+const (
+	MutationAsync MutationWait = iota //return once the mutation is queued
+	MutationSync                      //SETTINGS mutations_sync=2, the server waits on every replica
+	MutationPoll                      //poll system.mutations until the mutations created by the statement have finished
 )
 
 type ORM struct {
 	SqlCommand   *util.Builder
 	SqlValues    []interface{}
 	SqlStructMap map[string]*Field
+	SqlBatch     []map[string]*Field //rows of a batch insert, SqlStructMap is the first
 	TableName    string
 	Mode         UseMode
 	Columns      map[string]struct{}
 	ColumnMode   int //1 use -1 exclude
 	ConnClose    bool
+	Order        string         //fields of the ORDER BY clause, kept per ORM for paging
+	Group        string         //fields of the GROUP BY clause, kept per ORM for paging
+	Params       []sql.NamedArg //stored procedure parameters, sql.Out for output
+	Conflict     []string       //non-nil makes Insert an upsert on these columns
+	Wait         MutationWait   //clickhouse Update/Delete, bounded by Serve.Timeout
+}
+
+// FieldNames 按名称排序的结构体字段 保证生成的SQL稳定
+// FieldNames returns the struct fields sorted by name so that generated SQL is deterministic
+func (orm *ORM) FieldNames() []string {
+	names := make([]string, 0, len(orm.SqlStructMap))
+	for k := range orm.SqlStructMap {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AutoIncrement 标签是否声明自增 MySQL为auto_increment SQLite为autoincrement
+// AutoIncrement reports whether tag declares an auto increment column, spelled
+// auto_increment on MySQL and autoincrement on SQLite
+func AutoIncrement(tag string) bool {
+	return strings.Contains(tag, "auto_increment") || strings.Contains(tag, "autoincrement")
+}
+
+// Generated 字段是否由数据库生成而不写入INSERT 即auto_increment字段
+// 但作为Upsert的冲突列且每行都有值时写入 否则冲突无法匹配已有行
+// Generated reports whether the database generates field, so that Insert
+// leaves it out: it is auto_increment, unless it is a conflict column of an
+// upsert that is set in every row, which must be inserted to match the
+// existing row
+func (orm *ORM) Generated(field string) bool {
+	f := orm.SqlStructMap[field]
+	if f == nil || !AutoIncrement(f.Tag) {
+		return false
+	}
+	var target bool
+	for _, c := range orm.Conflict {
+		if c == field {
+			target = true
+		}
+	}
+	if !target {
+		return true
+	}
+	for _, row := range orm.Rows() {
+		if v := reflect.ValueOf(row[field].Value()); !v.IsValid() || v.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// Rows 插入的全部行 非批量时为SqlStructMap
+// Rows returns the rows to insert: SqlBatch, or SqlStructMap alone
+func (orm *ORM) Rows() []map[string]*Field {
+	if len(orm.SqlBatch) > 0 {
+		return orm.SqlBatch
+	}
+	return []map[string]*Field{orm.SqlStructMap}
+}
+
+// ColumnNames 按名称排序的Columns
+// ColumnNames returns Columns sorted by name
+func (orm *ORM) ColumnNames() []string {
+	names := make([]string, 0, len(orm.Columns))
+	for k := range orm.Columns {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// InsertResult 以查询方式执行的Insert结果(如OUTPUT INSERTED) 保存全部新id
+// InsertResult is the sql.Result of an insert run as a query (e.g. with
+// OUTPUT INSERTED), holding the ids of every new row
+type InsertResult []int64
+
+func (r InsertResult) LastInsertId() (int64, error) {
+	if len(r) == 0 {
+		return 0, nil
+	}
+	return r[len(r)-1], nil
+}
+
+func (r InsertResult) RowsAffected() (int64, error) {
+	return int64(len(r)), nil
 }
 
 type SqlRows struct {
 	*sql.Rows
+	//方言的类型转换 返回false时使用默认转换
+	//Convert lets a dialect convert raw column bytes by database type; false falls back to the defaults
+	Convert func(typ string, value []byte) (interface{}, bool)
 }
 
+// GetDataSet 读取全部结果集 跳过没有列的结果(如存储过程中的更新语句)
+// GetDataSet reads every result set, skipping those without columns such as the updates inside a procedure
 func (rows *SqlRows) GetDataSet() (ds *DataSet, err error) {
-	var dt *DataTable
-	dt, err = rows.GetDataTable()
-	if err != nil {
-		return
-	}
 	ds = new(DataSet)
-	ds.Tables = append(ds.Tables, dt)
-	for rows.NextResultSet() {
+	for next := true; next; next = rows.NextResultSet() {
+		var dt *DataTable
 		dt, err = rows.GetDataTable()
+		if err == errNoColumn {
+			continue
+		}
 		if err != nil {
-			return
+			return nil, err
 		}
 		ds.Tables = append(ds.Tables, dt)
 	}
-	return
+	return ds, rows.Err()
 }
 
 func (rows *SqlRows) GetDataTable() (dt *DataTable, err error) {
@@ -168,7 +312,7 @@ func (rows *SqlRows) GetDataTable() (dt *DataTable, err error) {
 	}
 	columnLen := len(columns)
 	if columnLen == 0 {
-		err = errors.New("no column")
+		err = errNoColumn
 		return
 	}
 	dt = new(DataTable)
@@ -193,6 +337,12 @@ func (rows *SqlRows) GetDataTable() (dt *DataTable, err error) {
 			switch r := value.(type) {
 			case []byte:
 				typ := strings.ToUpper(dt.Columns[i].Type)
+				if rows.Convert != nil {
+					if v, ok := rows.Convert(typ, r); ok {
+						row[name] = v
+						continue
+					}
+				}
 				switch typ {
 				case "INT", "SMALLINT", "TINYINT", "MEDIUMINT", "BIT":
 					row[name] = util.ToInt(value)
@@ -80,3 +80,41 @@ func Benchmark_DataTable(b *testing.B) {
 		}
 	})
 }
+
+func TestMerge(t *testing.T) {
+	dt := Merge(GetTestDataTable(), GetTestDataTable())
+	if dt.Count != 12 || len(dt.Columns) != 4 {
+		t.Fatal(dt.Count, len(dt.Columns))
+	}
+	page := dt.OrderBy("id desc").Limit(3, 1)
+	if page.Count != 3 || page.Rows[0]["id"] != 7 || page.Rows[2]["id"] != 5 {
+		t.Fatal(page.Rows)
+	}
+	sums := dt.Sum("name", "id", "money")
+	if sums.Count != 2 {
+		t.Fatal(sums.Rows)
+	}
+	for _, row := range sums.Rows {
+		if row["name"] == "US" && (row["id"] != int64(22) || row["money"] != 3.99*4) {
+			t.Fatal(row)
+		}
+	}
+}
+
+func TestNamed(t *testing.T) {
+	params := map[string]*Field{"id": {Val: 1}, "name": {Val: "CN"}}
+	p := func(n int) string { return "@p" + util.ToString(n) }
+	command, values, err := Named("SELECT count(1), ':id' AS s, x::text FROM t -- :none\nWHERE id=:id OR name=:name OR pid=:id", params, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if command != "SELECT count(1), ':id' AS s, x::text FROM t -- :none\nWHERE id=@p1 OR name=@p2 OR pid=@p3" {
+		t.Fatal(command)
+	}
+	if len(values) != 3 || values[0] != 1 || values[1] != "CN" || values[2] != 1 {
+		t.Fatal(values)
+	}
+	if _, _, err = Named("SELECT * FROM t WHERE id=:code", params, p); err != ErrNoParam {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package datatable
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+)
+
+var (
+	ErrNoRows           = errors.New("data line is empty")
+	ErrPoolExhausted    = errors.New("maximum number of connections exceeded")
+	ErrVerification     = errors.New("verification failed")
+	ErrUnknownCondition = errors.New("the query condition does not exist")
+	ErrDuplicateKey     = errors.New("duplicate key")
+	ErrDeadlock         = errors.New("deadlock")
+	ErrNoParam          = errors.New("named parameter not bound")
+	errNoColumn         = errors.New("no column")
+)
+
+// ErrorNumber 返回驱动错误中的数据库错误码
+// 支持 SQLErrorNumber() 方法(go-mssqldb) 以及 Number(go-sql-driver/mysql)、Code(clickhouse-go) 字段
+// ErrorNumber extracts the native error code from a driver error, via a
+// SQLErrorNumber method (go-mssqldb) or a Number (go-sql-driver/mysql)
+// or Code (clickhouse-go) field.
+func ErrorNumber(err error) (int, bool) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if e, ok := err.(interface{ SQLErrorNumber() int32 }); ok {
+			return int(e.SQLErrorNumber()), true
+		}
+		v := reflect.ValueOf(err)
+		if v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			continue
+		}
+		for _, name := range []string{"Number", "Code"} {
+			f := v.FieldByName(name)
+			switch f.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				return int(f.Int()), true
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				return int(f.Uint()), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// IsConnError 是否为连接断开类错误
+// IsConnError reports whether err means the connection to the server was lost
+func IsConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"broken pipe", "connection reset", "connection refused", "invalid connection", "bad connection"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
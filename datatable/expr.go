@@ -118,6 +118,9 @@ func Where(buf []byte) (*Expr, error) {
 	tokens := lex(buf)
 	exp := &Expr{Tokens: tokens, length: len(tokens)}
 	stmts := exp.stmt()
+	if stmts == nil {
+		return exp, errors.New("stmt nil")
+	}
 	if stmts.Rh == nil {
 		switch v := stmts.Lh.(type) {
 		case *Wheres:
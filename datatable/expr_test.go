@@ -39,3 +39,14 @@ func TestExpr(t *testing.T) {
 		t.Log(i, exp.WhereExpr)
 	}
 }
+
+// TestWhereEmpty 空表达式返回错误 不再panic
+// TestWhereEmpty checks that an expression without statements fails instead of panicking
+func TestWhereEmpty(t *testing.T) {
+	for _, s := range []string{"", "   "} {
+		exp, err := Where([]byte(s))
+		if err == nil || exp == nil || exp.WhereExpr != nil {
+			t.Fatalf("%q: %v %v", s, exp, err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package datatable
+
+import "github.com/BlueStorm001/gsql/util"
+
+// Merge 合并多个表的行 列按名称合并
+// Merge appends the rows of several tables into one, uniting their columns by name
+func Merge(tables ...*DataTable) *DataTable {
+	dataTable := &DataTable{}
+	columns := make(map[string]struct{})
+	for _, dt := range tables {
+		if dt == nil {
+			continue
+		}
+		if dataTable.Name == "" {
+			dataTable.Name = dt.Name
+		}
+		for _, column := range dt.Columns {
+			if _, ok := columns[column.Name]; !ok {
+				columns[column.Name] = struct{}{}
+				dataTable.Columns = append(dataTable.Columns, column)
+			}
+		}
+		dataTable.Rows = append(dataTable.Rows, dt.Rows...)
+	}
+	dataTable.Count = len(dataTable.Rows)
+	return dataTable
+}
+
+// Limit 取从offset开始的limit行
+// Limit returns at most limit rows starting at offset
+func (dt *DataTable) Limit(limit int, offset ...int) *DataTable {
+	dataTable := &DataTable{Name: dt.Name, Columns: dt.Columns}
+	start := 0
+	if len(offset) > 0 && offset[0] > 0 {
+		start = offset[0]
+	}
+	if start < len(dt.Rows) {
+		end := len(dt.Rows)
+		if limit >= 0 && start+limit < end {
+			end = start + limit
+		}
+		dataTable.Rows = dt.Rows[start:end]
+	}
+	dataTable.Count = len(dataTable.Rows)
+	return dataTable
+}
+
+// Sum 按query中的列分组 并对columns求和 例如 Sum("name", "count")
+// Sum groups the rows by the columns in query and adds up columns within each group
+func (dt *DataTable) Sum(query string, columns ...string) *DataTable {
+	exp := GroupBy([]byte(query))
+	dataTable := &DataTable{Name: dt.Name}
+	for _, item := range exp.GroupExpr {
+		dataTable.Columns = append(dataTable.Columns, &Column{Name: item.Name})
+	}
+	for _, column := range columns {
+		dataTable.Columns = append(dataTable.Columns, &Column{Name: column, Type: "DECIMAL"})
+	}
+	groups := make(map[string]map[string]interface{})
+	for _, dr := range dt.Rows {
+		var key string
+		for _, item := range exp.GroupExpr {
+			key += "$" + util.ToString(dr[item.Name]) + "$"
+		}
+		row, ok := groups[key]
+		if !ok {
+			row = make(map[string]interface{})
+			for _, item := range exp.GroupExpr {
+				row[item.Name] = dr[item.Name]
+			}
+			groups[key] = row
+			dataTable.Rows = append(dataTable.Rows, row)
+		}
+		for _, column := range columns {
+			row[column] = sum(row[column], dr[column])
+		}
+	}
+	dataTable.Count = len(dataTable.Rows)
+	return dataTable
+}
+
+func sum(a, b interface{}) interface{} {
+	switch b.(type) {
+	case float32, float64:
+		return util.ToFloat64(a) + util.ToFloat64(b)
+	}
+	switch a.(type) {
+	case float64:
+		return util.ToFloat64(a) + util.ToFloat64(b)
+	}
+	return util.ToInt64(a) + util.ToInt64(b)
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package datatable
+
+import (
+	"github.com/BlueStorm001/gsql/util"
+)
+
+// Named 将SQL中的:name替换为方言占位符 并按出现顺序返回参数值
+// 引号 注释中的内容以及::类型转换不做替换
+// Named rewrites the :name parameters in command to the dialect's placeholders
+// and returns the bound values in order of appearance. Quoted strings and
+// identifiers, comments and :: casts are left alone.
+func Named(command string, params map[string]*Field, placeholder func(n int) string) (string, []interface{}, error) {
+	var values []interface{}
+	builder := util.Builder{}
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			j := i + 1
+			for ; j < len(command); j++ {
+				if command[j] == '\\' && c == '\'' {
+					j++
+				} else if command[j] == c {
+					if j+1 < len(command) && command[j+1] == c {
+						j++
+						continue
+					}
+					break
+				}
+			}
+			if j >= len(command) {
+				j = len(command) - 1
+			}
+			builder.Append(command[i : j+1])
+			i = j
+		case c == '-' && i+1 < len(command) && command[i+1] == '-':
+			j := i
+			for j < len(command) && command[j] != '\n' {
+				j++
+			}
+			builder.Append(command[i:j])
+			i = j - 1
+		case c == '/' && i+1 < len(command) && command[i+1] == '*':
+			j := i + 2
+			for j+1 < len(command) && !(command[j] == '*' && command[j+1] == '/') {
+				j++
+			}
+			j += 2
+			if j > len(command) {
+				j = len(command)
+			}
+			builder.Append(command[i:j])
+			i = j - 1
+		case c == ':' && i+1 < len(command) && command[i+1] == ':':
+			builder.Append("::")
+			i++
+		case c == ':' && i+1 < len(command) && isNameStart(command[i+1]):
+			j := i + 1
+			for j < len(command) && (isNameStart(command[j]) || (command[j] >= '0' && command[j] <= '9')) {
+				j++
+			}
+			f, ok := params[command[i+1:j]]
+			if !ok {
+				return "", nil, ErrNoParam
+			}
+			values = append(values, f.Val)
+			builder.Append(placeholder(len(values)))
+			i = j - 1
+		default:
+			builder.AppendByte(c)
+		}
+	}
+	return builder.String(), values, nil
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
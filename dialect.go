@@ -0,0 +1,91 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gsql
+
+import (
+	"fmt"
+	"github.com/BlueStorm001/gsql/clickhouse"
+	"github.com/BlueStorm001/gsql/datatable"
+	"github.com/BlueStorm001/gsql/mssqls"
+	"github.com/BlueStorm001/gsql/mysqls"
+	"github.com/BlueStorm001/gsql/pgsqls"
+	"github.com/BlueStorm001/gsql/sqlites"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Dialect 方言工厂 接收共享的datatable.Serve
+// Dialect creates the ISQL of a database engine around the shared *datatable.Serve
+type Dialect func(serve *datatable.Serve) datatable.ISQL
+
+// dialects 已注册的方言 内置方言在包变量初始化时注册 早于任何init
+// dialects holds the registered dialects; the built-in ones are set up with
+// the variable itself so that package-level Serves can use them before init
+var dialects = struct {
+	sync.RWMutex
+	m map[DatabaseType]Dialect
+}{m: map[DatabaseType]Dialect{
+	MySql:      func(serve *datatable.Serve) datatable.ISQL { return mysqls.New(serve) },
+	MSSql:      func(serve *datatable.Serve) datatable.ISQL { return mssqls.New(serve) },
+	Clickhouse: func(serve *datatable.Serve) datatable.ISQL { return clickhouse.New(serve) },
+	PgSql:      func(serve *datatable.Serve) datatable.ISQL { return pgsqls.New(serve) },
+	Sqlite:     func(serve *datatable.Serve) datatable.ISQL { return sqlites.New(serve) },
+}}
+
+// RegisterDialect 注册方言 供Database和NewDrive按名称使用 同名覆盖
+// RegisterDialect makes a dialect available to Database and NewDrive under
+// name, replacing any dialect registered before under the same name
+func RegisterDialect(name DatabaseType, dialect Dialect) {
+	if dialect == nil {
+		panic("gsql: RegisterDialect dialect is nil")
+	}
+	dialects.Lock()
+	dialects.m[name] = dialect
+	dialects.Unlock()
+}
+
+// Dialects 已注册的方言 按名称排序
+// Dialects returns the names of the registered dialects, sorted
+func Dialects() []DatabaseType {
+	dialects.RLock()
+	defer dialects.RUnlock()
+	names := make([]DatabaseType, 0, len(dialects.m))
+	for name := range dialects.m {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+func newISQL(baseType DatabaseType, serve *datatable.Serve) (datatable.ISQL, error) {
+	dialects.RLock()
+	dialect, ok := dialects.m[baseType]
+	dialects.RUnlock()
+	if !ok {
+		var names []string
+		for _, name := range Dialects() {
+			names = append(names, string(name))
+		}
+		return nil, fmt.Errorf("%w %q, available: %s", ErrUnknownDialect, baseType, strings.Join(names, ", "))
+	}
+	return dialect(serve), nil
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gsql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// testDriver 测试用驱动 不连接数据库
+// testDriver is an in-memory driver that answers every query with a single count row
+type testDriver struct {
+	delay   time.Duration
+	pings   int64
+	queries int64
+	execs   int64
+	mu      sync.Mutex
+	fails   []error //returned by the next statements, in order
+	down    int32   //pings fail while set
+	last    string  //last statement run
+	args    []driver.Value
+	rows    func(query string) ([]string, [][]driver.Value) //answers queries instead of the count row when set
+}
+
+// testError 模拟 go-sql-driver/mysql 的错误
+// testError mimics *mysql.MySQLError
+type testError struct {
+	Number  uint16
+	Message string
+}
+
+func (e *testError) Error() string {
+	return "Error " + strconv.Itoa(int(e.Number)) + ": " + e.Message
+}
+
+func (d *testDriver) record(query string, args []driver.Value) {
+	d.mu.Lock()
+	d.last, d.args = query, args
+	d.mu.Unlock()
+}
+
+func (d *testDriver) fail() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.fails) == 0 {
+		return nil
+	}
+	err := d.fails[0]
+	d.fails = d.fails[1:]
+	return err
+}
+
+var testDrivers int64
+
+func newTestDrive(d *testDriver) func() (*sql.DB, error) {
+	name := "gsqltest" + strconv.FormatInt(atomic.AddInt64(&testDrivers, 1), 10)
+	sql.Register(name, d)
+	return func() (*sql.DB, error) {
+		return sql.Open(name, "")
+	}
+}
+
+func (d *testDriver) Open(string) (driver.Conn, error) {
+	return &testConn{d: d}, nil
+}
+
+type testConn struct {
+	d *testDriver
+}
+
+func (c *testConn) Prepare(query string) (driver.Stmt, error) {
+	return &testStmt{d: c.d, query: query}, nil
+}
+
+func (c *testConn) Close() error {
+	return nil
+}
+
+func (c *testConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *testConn) Commit() error {
+	return nil
+}
+
+func (c *testConn) Rollback() error {
+	return nil
+}
+
+func (c *testConn) Ping(ctx context.Context) error {
+	atomic.AddInt64(&c.d.pings, 1)
+	if atomic.LoadInt32(&c.d.down) == 1 {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+type testStmt struct {
+	d     *testDriver
+	query string
+}
+
+func (s *testStmt) Close() error {
+	return nil
+}
+
+func (s *testStmt) NumInput() int {
+	return -1
+}
+
+func (s *testStmt) Exec(args []driver.Value) (driver.Result, error) {
+	atomic.AddInt64(&s.d.execs, 1)
+	s.d.record(s.query, args)
+	time.Sleep(s.d.delay)
+	if err := s.d.fail(); err != nil {
+		return nil, err
+	}
+	return testResult(1000), nil
+}
+
+// testResult 每次插入返回id 1000
+// testResult reports 1000 as the id of every insert
+type testResult int64
+
+func (r testResult) LastInsertId() (int64, error) {
+	return int64(r), nil
+}
+
+func (r testResult) RowsAffected() (int64, error) {
+	return 1, nil
+}
+
+func (s *testStmt) Query(args []driver.Value) (driver.Rows, error) {
+	atomic.AddInt64(&s.d.queries, 1)
+	s.d.record(s.query, args)
+	time.Sleep(s.d.delay)
+	if err := s.d.fail(); err != nil {
+		return nil, err
+	}
+	if s.d.rows != nil {
+		columns, values := s.d.rows(s.query)
+		return &testRows{columns: columns, values: values}, nil
+	}
+	return &testRows{columns: []string{"count"}, values: [][]driver.Value{{int64(1)}}}, nil
+}
+
+type testRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *testRows) Columns() []string {
+	return r.columns
+}
+
+func (r *testRows) Close() error {
+	return nil
+}
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
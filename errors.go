@@ -0,0 +1,102 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gsql
+
+import (
+	"errors"
+	"github.com/BlueStorm001/gsql/clickhouse"
+	"github.com/BlueStorm001/gsql/datatable"
+)
+
+// 可用 errors.Is 判断的错误
+// Errors that can be tested with errors.Is
+var (
+	ErrNoRows           = datatable.ErrNoRows           //GetStruct on an empty result
+	ErrPoolExhausted    = datatable.ErrPoolExhausted    //no ORM became free within Timeout
+	ErrVerification     = datatable.ErrVerification     //table name, condition or SQL rejected by util.Verify
+	ErrUnknownCondition = datatable.ErrUnknownCondition //Where refers to a field missing from the struct
+	ErrDuplicateKey     = datatable.ErrDuplicateKey     //unique constraint violated
+	ErrDeadlock         = datatable.ErrDeadlock         //chosen as deadlock victim
+	ErrNoParam          = datatable.ErrNoParam          //:name in raw SQL missing from the params
+	ErrParamType        = errors.New("params must be a struct, a pointer to one or a map[string]interface{}")
+	ErrCircuitOpen      = errors.New("circuit breaker is open")
+	ErrNoShardKey       = errors.New("the query does not filter on the shard key")
+	ErrNoServe          = errors.New("Serve must be created first")
+	ErrNoDialect        = errors.New("ISQL is null")
+	ErrUnknownDialect   = errors.New("unknown database type")
+	ErrNoORM            = errors.New("ORM must be created first")
+)
+
+// QueryError 执行语句时数据库返回的错误
+// QueryError is returned when the database rejects a statement. It wraps
+// the driver error and matches ErrDuplicateKey or ErrDeadlock with errors.Is
+// when the dialect recognizes the native code.
+type QueryError struct {
+	SQL     string
+	Args    []interface{}
+	Dialect DatabaseType
+	Code    int //native error code, 0 if unknown
+	Err     error
+	kind    error
+}
+
+func (e *QueryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+func (e *QueryError) Is(target error) bool {
+	return e.kind != nil && e.kind == target
+}
+
+// connectError 连接(或Drive)失败 在执行语句之前
+// connectError is a failure to connect or of the drive function, before any statement ran
+type connectError struct {
+	err error
+}
+
+func (e *connectError) Error() string {
+	return e.err.Error()
+}
+
+func (e *connectError) Unwrap() error {
+	return e.err
+}
+
+// queryError 将执行语句的错误包装为QueryError 连接 熔断和等待超时原样返回
+// queryError wraps an error of statement execution in a QueryError;
+// connect failures, an open circuit and mutation timeouts are returned as they are
+func (o *ORM) queryError(err error) error {
+	var ce *connectError
+	if errors.As(err, &ce) {
+		return ce.err
+	}
+	if err == ErrCircuitOpen || errors.Is(err, clickhouse.ErrMutationTimeout) {
+		return err
+	}
+	e := &QueryError{SQL: o.SqlCommand.ToString(), Args: append([]interface{}(nil), o.SqlValues...), Dialect: o.s.kind, Err: err}
+	e.Code, _ = datatable.ErrorNumber(err)
+	e.kind = o.s.ISQL.Classify(err)
+	return e
+}
@@ -21,15 +21,14 @@
 package gsql
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	"github.com/BlueStorm001/gsql/clickhouse"
 	"github.com/BlueStorm001/gsql/datatable"
-	"github.com/BlueStorm001/gsql/mssqls"
-	"github.com/BlueStorm001/gsql/mysqls"
 	"github.com/BlueStorm001/gsql/util"
 	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -41,12 +40,34 @@ const (
 	MSSql      DatabaseType = "MSSql"
 	MySql      DatabaseType = "MySql"
 	Clickhouse DatabaseType = "Clickhouse"
+	PgSql      DatabaseType = "PgSql"
+	Sqlite     DatabaseType = "Sqlite"
 )
 
 type Serve struct {
+	//atomic counters first for 64-bit alignment
+	waits     int64
+	waitTime  int64
+	timeouts  int64
+	disposals int64
 	*datatable.Serve
-	mu  sync.Mutex
-	chs chan *ORM
+	mu     sync.Mutex
+	pool   *pool
+	slow   *slowLog
+	logger Logger
+	debug  bool
+	//read/write splitting
+	kind     DatabaseType
+	replicas []*Replica
+	balancer Balancer
+	eject    time.Duration
+
+	retryPolicy *RetryPolicy
+	breaker     *breaker
+	healthStop  chan struct{}
+	healthDone  chan struct{} //closed when the checker has exited
+	unhealthy   int32
+	keyGen      KeyGenerator
 }
 
 func NewServer(host string, port int) *Serve {
@@ -66,22 +87,14 @@ func NewDrive(baseType DatabaseType, drive func() (db *sql.DB, err error)) *Serv
 
 func (s *Serve) Database(baseType DatabaseType, database string) *Serve {
 	s.Serve.Database = database
-	switch baseType {
-	case MySql:
-		s.ISQL = &mysqls.Serve{Serve: s.Serve}
-	case MSSql:
-		s.ISQL = &mssqls.Serve{Serve: s.Serve}
-	case Clickhouse:
-		s.ISQL = &clickhouse.Serve{Serve: s.Serve}
-	default:
-		s.Error = errors.New("unknown database type")
-	}
+	s.kind = baseType
+	s.ISQL, s.Error = newISQL(baseType, s.Serve)
 	return s
 }
 
 func (s *Serve) Config(connectMax, timeout int) *Serve {
 	if connectMax > 0 {
-		s.ConnectMax = connectMax
+		s.Resize(connectMax)
 	}
 	if timeout > 0 {
 		s.Timeout = timeout
@@ -89,6 +102,28 @@ func (s *Serve) Config(connectMax, timeout int) *Serve {
 	return s
 }
 
+// Resize 调整最大连接数 可在使用中调整
+// Resize changes ConnectMax at runtime, resizing the ORM pool and the sql.DB limits
+func (s *Serve) Resize(connectMax int) *Serve {
+	if connectMax <= 0 {
+		return s
+	}
+	s.mu.Lock()
+	s.ConnectMax = connectMax
+	if s.pool != nil {
+		s.resize(connectMax)
+	}
+	s.mu.Unlock()
+	if s.ISQL != nil {
+		s.ISQL.SetMaxConns(connectMax)
+	}
+	for _, r := range s.replicas {
+		r.ConnectMax = connectMax
+		r.ISQL.SetMaxConns(connectMax)
+	}
+	return s
+}
+
 func (s *Serve) Login(user, pass string) *Serve {
 	if s.Auth == nil {
 		s.Auth = &datatable.Auth{User: user, Pass: pass}
@@ -99,6 +134,17 @@ func (s *Serve) Login(user, pass string) *Serve {
 	return s
 }
 
+// Options 内置DSN的连接参数
+// Options tune the DSN built from Host, Port, Auth and Database when no drive is set
+type Options = datatable.Options
+
+// Option 设置内置DSN的连接参数 未调用NewDrive时由方言自行打开连接
+// Option sets the DSN options; without NewDrive the dialect opens the sql.DB itself
+func (s *Serve) Option(options Options) *Serve {
+	s.Options = options
+	return s
+}
+
 func (s *Serve) NewDrive(drive func(s *datatable.Serve) (db *sql.DB, err error)) *Serve {
 	s.DriveServe = drive
 	s.DriveMode = 1
@@ -107,65 +153,68 @@ func (s *Serve) NewDrive(drive func(s *datatable.Serve) (db *sql.DB, err error))
 
 type ORM struct {
 	*datatable.ORM
-	Error        error
-	ErrorSQL     string
-	Id           int
-	ST           time.Time     //execution start time
-	TC           time.Duration //time consuming
-	s            *Serve
-	processLock  *util.Mutex
-	chanState    bool
-	chanComplete chan struct{}
+	Error       error
+	Id          int
+	ST          time.Time     //execution start time
+	TC          time.Duration //time consuming
+	s           *Serve
+	processLock *util.Mutex
+	chanState   bool
+	gen         uint64 //incremented on every checkout
+	primary     bool   //read from the primary
+	shardKey    string //field the Where clause must filter on, kept through reset like TableName
+	shardBound  bool
+	idempotent  bool
+	in          interface{} //struct or slice passed to NewStruct, for writing keys back
 }
 
 type SqlResult struct {
 	*datatable.SqlResult
+	Retries int //number of times the statement was retried
 }
 
 func (s *Serve) NewStruct(table string, inStruct interface{}) *ORM {
+	return s.NewStructContext(context.Background(), table, inStruct)
+}
+
+// NewStructContext 与NewStruct相同 等待空闲ORM时可由ctx取消
+// NewStructContext is like NewStruct but stops waiting for a free handle when ctx is done
+func (s *Serve) NewStructContext(ctx context.Context, table string, inStruct interface{}) *ORM {
 	if util.Verify(table) {
-		return &ORM{Error: errors.New("verification failed")}
+		return &ORM{Error: ErrVerification}
 	}
-	if s.chs == nil {
+	if s.pool == nil {
 		s.mu.Lock()
-		if s.chs == nil {
-			s.chs = make(chan *ORM, s.ConnectMax)
-			for i := 0; i < s.ConnectMax; i++ {
-				s.chs <- &ORM{ORM: &datatable.ORM{SqlCommand: util.NewBuilder()}, s: s, Id: i + 1, processLock: new(util.Mutex), chanComplete: make(chan struct{})}
-			}
+		if s.pool == nil {
+			s.pool = newPool(s, s.ConnectMax)
 		}
 		s.mu.Unlock()
 	}
-	orm := s.GetORM()
+	orm := s.GetORMContext(ctx)
 	if orm.Error == nil {
 		orm.TableName = table
-		orm.SqlStructMap = GetStruct(inStruct)
+		orm.setStruct(inStruct)
 	}
 	return orm
 }
 
 func (s *Serve) GetORM() *ORM {
+	return s.GetORMContext(context.Background())
+}
+
+// GetORMContext 阻塞等待空闲ORM 直到超时或ctx结束
+// GetORMContext blocks until a handle is free, Timeout elapses or ctx is done
+func (s *Serve) GetORMContext(ctx context.Context) *ORM {
 	if err := s.error(); err != nil {
 		return &ORM{Error: err}
 	}
-	for i := 0; i < 10*s.Timeout; i++ {
-		select {
-		case c := <-s.chs:
-			c.chanState = true
-			go func(orm *ORM) {
-				select {
-				case <-orm.chanComplete:
-					return
-				case <-time.After(time.Second * time.Duration(s.Timeout)):
-					orm.Dispose()
-				}
-			}(c)
-			return c
-		default:
-			time.Sleep(time.Millisecond * 100)
-		}
+	orm, err := s.acquire(ctx)
+	if err != nil {
+		return &ORM{Error: err}
 	}
-	return &ORM{Error: errors.New("maximum number of connections exceeded")}
+	orm.chanState = true
+	orm.shardKey = ""
+	return orm
 }
 
 func (o *ORM) SetStruct(inStruct interface{}) *ORM {
@@ -173,10 +222,34 @@ func (o *ORM) SetStruct(inStruct interface{}) *ORM {
 		o.Error = err
 		return o
 	}
-	o.SqlStructMap = GetStruct(inStruct)
+	o.setStruct(inStruct)
 	return o
 }
 
+// setStruct 切片作为批量插入的行 其余同GetStruct
+// setStruct takes a slice as the rows of a batch insert, anything else as in GetStruct
+func (o *ORM) setStruct(inStruct interface{}) {
+	o.in = inStruct
+	o.SqlBatch = nil
+	v := reflect.ValueOf(inStruct)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		o.SqlStructMap = GetStruct(inStruct)
+		return
+	}
+	if v.Len() == 0 {
+		o.Error = ErrNoRows
+		return
+	}
+	o.SqlBatch = make([]map[string]*datatable.Field, v.Len())
+	for i := range o.SqlBatch {
+		o.SqlBatch[i] = GetStruct(v.Index(i).Interface())
+	}
+	o.SqlStructMap = o.SqlBatch[0]
+}
+
 func (o *ORM) ColumnUse(columns ...string) *ORM {
 	if len(o.SqlStructMap) > 0 && len(columns) > 0 {
 		o.processLock.Lock()
@@ -270,6 +343,7 @@ func (orm *ORM) Insert(columns ...string) *ORM {
 	o.processLock.Lock()
 	o.ST = time.Now()
 	o.Mode = datatable.Add
+	o.generateKeys()
 	o.Error = o.s.ISQL.Insert(o.ORM)
 	return o
 }
@@ -285,6 +359,32 @@ func (o *ORM) InsertExclude(columns ...string) *ORM {
 	return o.Insert()
 }
 
+// Upsert 插入 与conflict列(默认主键)冲突时更新其余字段 MySQL按全部唯一键判断冲突
+// 自增主键有值时随语句写入以匹配已有行
+// Upsert inserts the row and updates its other fields when it conflicts on
+// the conflict columns, the primary key by default; MySQL ignores conflict
+// and checks every unique key. An auto_increment key is inserted when it is
+// set, so that the existing row matches. MSSQL and ClickHouse do not support it.
+func (orm *ORM) Upsert(conflict ...string) *ORM {
+	o := orm.get()
+	if err := o.error(); err != nil {
+		o.Error = err
+		return o
+	}
+	if len(conflict) == 0 {
+		if k, _ := key(o.ORM); k != "" {
+			conflict = []string{k}
+		}
+	}
+	o.processLock.Lock()
+	o.ST = time.Now()
+	o.Mode = datatable.Add
+	o.Conflict = append([]string{}, conflict...)
+	o.generateKeys()
+	o.Error = o.s.ISQL.Insert(o.ORM)
+	return o
+}
+
 func (orm *ORM) Update(columns ...string) *ORM {
 	o := orm.get()
 	if err := o.error(); err != nil {
@@ -329,12 +429,40 @@ func (orm *ORM) Delete() *ORM {
 	return o
 }
 
+// MutationWait ClickHouse变更的等待方式
+// MutationWait selects how ClickHouse Update and Delete wait for their mutation
+type MutationWait = datatable.MutationWait
+
+const (
+	MutationAsync = datatable.MutationAsync
+	MutationSync  = datatable.MutationSync
+	MutationPoll  = datatable.MutationPoll
+)
+
+// WaitMutation ClickHouse的Update/Delete等待变更完成后返回 最长等待Serve的超时时间 其它数据库忽略
+// WaitMutation makes ClickHouse Update and Delete return only once their
+// mutation has finished, for at most the Serve's timeout; a failed mutation
+// is reported as a *clickhouse.MutationError. Other databases ignore it.
+func (o *ORM) WaitMutation(wait MutationWait) *ORM {
+	if o.ORM != nil {
+		o.Wait = wait
+	}
+	return o
+}
+
 func (o *ORM) Where(wheres ...string) *ORM {
 	if err := o.error(); err != nil {
 		o.Error = err
 		return o
 	}
 	o.Error = o.s.ISQL.Where(o.ORM, wheres...)
+	if o.shardKey != "" {
+		for _, w := range wheres {
+			if field, _ := util.GetFieldName(w); field == o.shardKey {
+				o.shardBound = true
+			}
+		}
+	}
 	return o
 }
 
@@ -381,7 +509,7 @@ func (o *ORM) Page(size int, page int) *ORM {
 
 func (o *ORM) AddSql(command string) *ORM {
 	if util.Verify(command) {
-		o.Error = errors.New("verification failed")
+		o.Error = ErrVerification
 		return o
 	}
 	command = strings.Replace(command, "\"", "'", -1)
@@ -390,21 +518,51 @@ func (o *ORM) AddSql(command string) *ORM {
 }
 
 func (o *ORM) Execute() *SqlResult {
-	if o.chanState {
-		o.chanComplete <- struct{}{}
-	}
+	return o.run(1)
+}
+
+// run 执行语句 skip为慢查询记录调用位置时跳过的栈帧数
+// run executes the statement; skip is the number of frames above run to the caller recorded in the slow log
+func (o *ORM) run(skip int) *SqlResult {
 	defer o.s.reset(o)
 	result := &SqlResult{SqlResult: new(datatable.SqlResult)}
 	if err := o.error(); err != nil {
 		result.Error = err
-		if o.ORM != nil && o.SqlCommand.Len() > 0 {
-			o.ErrorSQL = o.SqlCommand.ToString()
-		}
 		return result
 	}
+	if o.shardKey != "" && !o.shardBound && o.Mode != datatable.Add {
+		result.Error = ErrNoShardKey
+		return result
+	}
+	o.execute(result)
+	for attempt := 1; result.Error != nil && o.s.retry(o, result.Error, attempt); attempt++ {
+		result.SqlResult = new(datatable.SqlResult)
+		result.Retries = attempt
+		o.execute(result)
+	}
+	if result.Error == nil && o.Mode == datatable.Add {
+		o.writeBack(result)
+	}
+	if slow := o.s.slow; slow != nil {
+		if tc := time.Since(o.ST); tc >= slow.threshold {
+			slow.add(&SlowQuery{SQL: util.Fingerprint(o.SqlCommand.String()), Args: append([]interface{}(nil), o.SqlValues...), Duration: tc, Caller: caller(skip + 1), Time: o.ST})
+		}
+	}
+	if result.Error != nil {
+		result.Error = o.queryError(result.Error)
+	}
+	if o.ConnClose {
+		if err := o.Close(); err != nil {
+			result.Error = err
+		}
+	}
+	return result
+}
+
+func (o *ORM) execute(result *SqlResult) {
 	switch o.Mode {
 	case datatable.Get, datatable.Count:
-		dt, err := o.s.ISQL.DataTable(o.ORM)
+		dt, err := o.s.read(o)
 		if err == nil {
 			if dt != nil {
 				result.DataTable = dt
@@ -417,22 +575,43 @@ func (o *ORM) Execute() *SqlResult {
 		} else {
 			result.Error = err
 		}
-	case datatable.Add, datatable.Set, datatable.Del:
-		res, err := o.s.ISQL.Execute(o.ORM)
+	case datatable.Call:
+		var ds *datatable.DataSet
+		err := o.s.guard(func() (err error) {
+			ds, err = o.s.ISQL.DataSet(o.ORM)
+			return
+		})
+		if err == nil {
+			for i, dt := range ds.Tables {
+				dt.Name = "Table"
+				if i > 0 {
+					dt.Name += strconv.Itoa(i)
+				}
+			}
+			result.DataSet = ds
+			if len(ds.Tables) > 0 {
+				result.DataTable = ds.Tables[0]
+				result.RowsAffected = int64(result.DataTable.Count)
+			}
+		} else {
+			result.Error = err
+		}
+	case datatable.Add, datatable.Set, datatable.Del, datatable.Exec:
+		var res sql.Result
+		err := o.s.guard(func() (err error) {
+			res, err = o.s.ISQL.Execute(o.ORM)
+			return
+		})
 		if err == nil {
 			result.RowsAffected, _ = res.RowsAffected()
 			result.LastInsertId, _ = res.LastInsertId()
+			if ids, ok := res.(datatable.InsertResult); ok {
+				result.InsertIds = ids
+			}
 		} else {
 			result.Error = err
 		}
 	}
-	if o.ConnClose {
-		result.Error = o.Close()
-	}
-	if result.Error != nil {
-		o.ErrorSQL = o.SqlCommand.ToString()
-	}
-	return result
 }
 
 func (o *ORM) Dispose() {
@@ -445,8 +624,17 @@ func (o *ORM) Close() error {
 	return o.s.Close()
 }
 
+// Close 停止健康检查并关闭主库和全部从库的连接
+// Close stops the health checker and closes the connections of the primary and every replica
 func (s *Serve) Close() error {
-	return s.ISQL.Close()
+	s.stopHealth()
+	err := s.ISQL.Close()
+	for _, r := range s.replicas {
+		if e := r.ISQL.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
 }
 
 func (o *ORM) GetSQL() (string, map[string]*datatable.Field) {
@@ -457,8 +645,11 @@ func (o *ORM) GetSQL() (string, map[string]*datatable.Field) {
 }
 
 func (r *SqlResult) GetStruct(inStruct interface{}) error {
+	if r.Error != nil {
+		return r.Error
+	}
 	if r.RowsAffected == 0 {
-		return errors.New("data line is empty")
+		return ErrNoRows
 	}
 	return util.SetStruct(inStruct, r.DataTable.Rows)
 }
@@ -467,10 +658,15 @@ func (o *ORM) get() *ORM {
 	if o.chanState {
 		return o
 	}
+	//the pool may hand o itself back, read what it carries first
+	shardKey := o.shardKey
 	orm := o.s.GetORM()
 	if orm.Error == nil {
 		orm.SqlStructMap = o.SqlStructMap
+		orm.SqlBatch = o.SqlBatch
+		orm.in = o.in
 		orm.TableName = o.TableName
+		orm.shardKey = shardKey
 	} else {
 		orm.Error = o.Error
 	}
@@ -483,74 +679,47 @@ func (s *Serve) reset(orm *ORM) {
 	}
 	orm.Mode = datatable.Not
 	orm.chanState = false
+	orm.primary = false
+	orm.shardBound = false
+	orm.idempotent = false
 	orm.Error = nil
 	orm.SqlCommand.Reset()
 	orm.SqlValues = nil
+	orm.Order = ""
+	orm.Group = ""
+	orm.Params = nil
+	orm.Conflict = nil
+	orm.Wait = datatable.MutationAsync
 	orm.Columns = nil
 	orm.ColumnMode = 0
 	orm.TC = time.Since(orm.ST)
 	if orm.processLock.State {
 		orm.processLock.Unlock()
 	}
-	select {
-	case s.chs <- orm:
-		break
-	default:
-		break
-	}
+	s.release(orm)
 }
 
 func (o *ORM) error() error {
 	if o == nil {
-		return errors.New(msg(505))
+		return ErrNoORM
 	}
 	if o.Error != nil {
 		return o.Error
 	}
-	if o.s == nil {
-		return errors.New(msg(504))
-	}
-	if o.s.ISQL == nil {
-		return errors.New(msg(502))
-	}
-	if o.s.chs == nil {
-		return errors.New(msg(505))
-	}
-	if o.s.Error != nil {
-		return errors.New("[500]" + o.s.Error.Error())
-	}
-	return nil
+	return o.s.error()
 }
 
 func (s *Serve) error() error {
 	if s == nil {
-		return errors.New(msg(504))
+		return ErrNoServe
 	}
 	if s.ISQL == nil {
-		return errors.New(msg(502))
-	}
-	if s.chs == nil {
-		return errors.New(msg(505))
+		return ErrNoDialect
 	}
-	if s.Error != nil {
-		return errors.New("[500]" + s.Error.Error())
-	}
-	return nil
-}
-
-func msg(code int) string {
-	switch code {
-	case 504:
-		return "[504]Serve must be created first!"
-	case 502:
-		return "[502]ISQL is null"
-	case 505:
-		return "[505]ORM must be created first!"
-	case 501:
-		return "[501]Auth must be created first!"
-	default:
-		return ""
+	if s.pool == nil {
+		return ErrNoORM
 	}
+	return s.Error
 }
 
 func GetStruct(in interface{}) map[string]*datatable.Field {
@@ -0,0 +1,95 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gsql
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// HealthCheck 后台按interval检查连接 失败时标记为不健康 interval<=0 停止检查
+// 执行语句时不再ping 仅在出现连接错误后先检查连接
+// HealthCheck pings the database every interval in the background,
+// marking the Serve unhealthy when the ping fails, and ejecting replicas
+// that do not answer. Statements themselves no longer ping; they only ping
+// first after a connection error. An interval <= 0
+// stops the checker.
+func (s *Serve) HealthCheck(interval time.Duration) *Serve {
+	s.stopHealth()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if interval > 0 && s.ISQL != nil && s.healthStop == nil {
+		s.healthStop = make(chan struct{})
+		s.healthDone = make(chan struct{})
+		go s.check(interval, s.healthStop, s.healthDone)
+	}
+	return s
+}
+
+// stopHealth 停止健康检查并等待其退出 之后不会再有检查打开连接
+// stopHealth stops the checker and waits for it to exit, so that no check reopens the connection afterwards
+func (s *Serve) stopHealth() {
+	s.mu.Lock()
+	stop, done := s.healthStop, s.healthDone
+	s.healthStop, s.healthDone = nil, nil
+	s.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Healthy 最近一次健康检查是否成功
+// Healthy reports whether the last health check succeeded
+func (s *Serve) Healthy() bool {
+	return atomic.LoadInt32(&s.unhealthy) == 0
+}
+
+func (s *Serve) check(interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		err := s.ISQL.Ping()
+		if err != nil {
+			if atomic.SwapInt32(&s.unhealthy, 1) == 0 {
+				s.logf("gsql: database is unhealthy: %v", err)
+			}
+			err = s.ISQL.Connect()
+		}
+		if err == nil && atomic.SwapInt32(&s.unhealthy, 0) == 1 {
+			s.logf("gsql: database has recovered")
+		}
+		now := time.Now()
+		for _, r := range s.replicas {
+			if r.available(now) {
+				if err := r.ISQL.Ping(); err != nil {
+					s.ejectReplica(r, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gsql
+
+import (
+	"github.com/BlueStorm001/gsql/datatable"
+	"github.com/BlueStorm001/gsql/util"
+	"reflect"
+	"strings"
+)
+
+// KeyGenerator 客户端主键生成器 用于没有自增字段的表(如ClickHouse)
+// KeyGenerator makes primary keys on the client, for tables without an auto_increment column such as ClickHouse
+type KeyGenerator func() interface{}
+
+// GenerateKeys 设置主键生成器 Insert时为值为零的primary key字段生成主键
+// GenerateKeys sets the generator used by Insert to fill primary key fields
+// (not auto_increment) that are still zero; the key is written back like a generated id
+func (s *Serve) GenerateKeys(gen KeyGenerator) *Serve {
+	s.keyGen = gen
+	return s
+}
+
+// key 主键字段 auto_increment优先于primary key
+// key returns the key field, preferring auto_increment over primary key, and whether the database generates it
+func key(orm *datatable.ORM) (string, bool) {
+	var primary string
+	for _, k := range orm.FieldNames() {
+		tag := orm.SqlStructMap[k].Tag
+		if datatable.AutoIncrement(tag) {
+			return k, true
+		}
+		if primary == "" && strings.Contains(tag, "primary key") {
+			primary = k
+		}
+	}
+	return primary, false
+}
+
+// generateKeys 为主键为零的行生成主键
+// generateKeys fills the zero primary keys of the rows to insert from the Serve's generator
+func (o *ORM) generateKeys() {
+	gen := o.s.keyGen
+	if gen == nil {
+		return
+	}
+	name, auto := key(o.ORM)
+	if name == "" || auto {
+		return
+	}
+	elems := o.structs()
+	for i, row := range o.Rows() {
+		f := row[name]
+		if f == nil {
+			continue
+		}
+		if v := reflect.ValueOf(f.Val); v.IsValid() && !v.IsZero() {
+			continue
+		}
+		f.Val = gen()
+		if i < len(elems) {
+			setKey(elems[i], name, f.Val)
+		}
+	}
+}
+
+// writeBack 将数据库生成的id写回传入的结构体
+// MySQL批量插入只返回第一个id 其余按auto_increment_increment=1连续推算 SQLite返回最后一个id
+// 批量Upsert中被更新的行不产生新id 无法推算 不写回
+// writeBack stores the generated ids in the auto_increment field of the
+// inserted structs. MySQL reports only the first id of a batch; the others
+// are assumed to follow it, which holds for auto_increment_increment=1.
+// SQLite reports the last one, and serializes writers so the batch is contiguous.
+// A batch Upsert may update some rows instead, so its ids cannot be inferred
+// and nothing is written back unless the database returned every id.
+func (o *ORM) writeBack(result *SqlResult) {
+	name, auto := key(o.ORM)
+	if name == "" || !auto || !o.Generated(name) {
+		return
+	}
+	rows := o.Rows()
+	ids := result.InsertIds
+	if len(ids) != len(rows) && result.LastInsertId > 0 {
+		switch {
+		case len(rows) == 1:
+			ids = []int64{result.LastInsertId}
+		case o.Conflict != nil:
+			//updated rows take no new id, the batch is not contiguous
+		case o.s.kind == MySql:
+			ids = make([]int64, len(rows))
+			for i := range ids {
+				ids[i] = result.LastInsertId + int64(i)
+			}
+		case o.s.kind == Sqlite:
+			ids = make([]int64, len(rows))
+			for i := range ids {
+				ids[i] = result.LastInsertId - int64(len(rows)-1-i)
+			}
+		}
+	}
+	if len(ids) != len(rows) {
+		return
+	}
+	elems := o.structs()
+	for i, id := range ids {
+		if f := rows[i][name]; f != nil {
+			f.Val = id
+		}
+		if i < len(elems) {
+			setKey(elems[i], name, id)
+		}
+	}
+}
+
+// structs 传入的结构体 按行的顺序
+// structs returns the structs passed to NewStruct or SetStruct, one per row
+func (o *ORM) structs() []reflect.Value {
+	v := reflect.ValueOf(o.in)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		return []reflect.Value{v}
+	case reflect.Slice, reflect.Array:
+		elems := make([]reflect.Value, v.Len())
+		for i := range elems {
+			if elems[i] = v.Index(i); elems[i].Kind() == reflect.Ptr {
+				elems[i] = elems[i].Elem()
+			}
+		}
+		return elems
+	}
+	return nil
+}
+
+// setKey 设置主键字段 结构体不可写(非指针)时忽略
+// setKey sets the key field, skipping structs that were not passed by pointer
+func setKey(v reflect.Value, name string, id interface{}) {
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	f := v.FieldByName(name)
+	val := reflect.ValueOf(id)
+	if !f.CanSet() || !val.IsValid() {
+		return
+	}
+	switch {
+	case f.Kind() == reflect.String && val.Kind() != reflect.String:
+		f.SetString(util.ToString(id))
+	case val.Type().ConvertibleTo(f.Type()):
+		f.Set(val.Convert(f.Type()))
+	}
+}
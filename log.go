@@ -0,0 +1,51 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gsql
+
+import "log"
+
+// Logger 日志输出 *log.Logger 即可满足
+// Logger receives diagnostics from a Serve; *log.Logger satisfies it
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Log 设置日志输出 默认使用标准库log
+// Log sets the logger used for diagnostics, the standard log package by default
+func (s *Serve) Log(logger Logger) *Serve {
+	s.logger = logger
+	return s
+}
+
+// Debug 调试模式 记录ORM获取时的调用栈 超时未归还时输出
+// Debug records the stack that acquired each ORM and logs it when the handle leaks
+func (s *Serve) Debug(debug bool) *Serve {
+	s.debug = debug
+	return s
+}
+
+func (s *Serve) logf(format string, v ...interface{}) {
+	if s.logger != nil {
+		s.logger.Printf(format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
@@ -25,61 +25,122 @@ import (
 	"errors"
 	"github.com/BlueStorm001/gsql/datatable"
 	"github.com/BlueStorm001/gsql/util"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 )
 
+var errOutName = errors.New("output parameters must be named")
+
 type Serve struct {
 	*datatable.Serve
-	conn *sql.DB
-	step int
+	*datatable.Conn
 }
 
-func (s *Serve) Connect() error {
-	var err error
-	if s == nil || s.conn == nil {
-		err = errors.New("conn is null")
-	} else {
-		err = s.conn.Ping()
+// New 创建方言 连接由Serve的字段或驱动函数打开
+// New creates the dialect for serve, connecting as serve's fields or drive function say
+func New(serve *datatable.Serve) *Serve {
+	s := &Serve{Serve: serve}
+	s.Conn = datatable.NewConn(serve, "sqlserver", s.DSN, nil)
+	return s
+}
+
+// DSN 由Host Port Auth Database Options生成go-mssqldb连接串
+// DSN builds a go-mssqldb data source name from Host, Port, Auth, Database and Options
+func (s *Serve) DSN() string {
+	port := s.Port
+	if port == 0 {
+		port = 1433
 	}
-	if err == nil {
-		return nil
+	u := &url.URL{Scheme: "sqlserver", Host: net.JoinHostPort(s.Host, strconv.Itoa(port))}
+	if s.Auth != nil {
+		u.User = url.UserPassword(s.Auth.User, s.Auth.Pass)
 	}
-	switch s.DriveMode {
-	case 1:
-		s.conn, s.Error = s.DriveServe(s.Serve)
-	case 2:
-		s.conn, s.Error = s.Drive()
+	params := url.Values{}
+	if s.Database != "" {
+		params.Set("database", s.Database)
+	}
+	switch s.Options.TLS {
+	case "":
+	case "skip-verify":
+		params.Set("encrypt", "true")
+		params.Set("TrustServerCertificate", "true")
 	default:
-		s.Error = errors.New("drive mode error")
+		params.Set("encrypt", s.Options.TLS)
+	}
+	for k, v := range s.Options.Settings {
+		params.Set(k, v)
+	}
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
+// Retryable 死锁(1205) 锁超时(1222) 连接断开
+// Retryable reports deadlocks (1205), lock timeouts (1222) and lost connections
+func (s *Serve) Retryable(err error) bool {
+	if datatable.IsConnError(err) {
+		return true
 	}
-	if s.Error != nil {
-		return s.Error
+	if code, ok := datatable.ErrorNumber(err); ok {
+		switch code {
+		case 1205, 1222:
+			return true
+		}
 	}
-	return s.conn.Ping()
+	return false
 }
 
-func (s *Serve) Close() error {
-	var err error
-	if s.conn != nil {
-		if err = s.conn.Close(); err == nil {
-			s.conn = nil
+// Classify 重复键(2627,2601) 死锁(1205)
+// Classify maps duplicate keys (2627, 2601) and deadlocks (1205) to their sentinel errors
+func (s *Serve) Classify(err error) error {
+	if code, ok := datatable.ErrorNumber(err); ok {
+		switch code {
+		case 2627, 2601:
+			return datatable.ErrDuplicateKey
+		case 1205:
+			return datatable.ErrDeadlock
 		}
 	}
-	return err
+	return nil
+}
+
+// Placeholder 第n个参数的占位符 go-mssqldb使用@p1 @p2...
+// Placeholder returns the placeholder for the n-th argument, counting from 1; go-mssqldb expects @p1, @p2...
+func (s *Serve) Placeholder(n int) string {
+	return "@p" + strconv.Itoa(n)
+}
+
+// bind 追加参数并返回占位符
+// bind appends value to the arguments and returns its placeholder
+func (s *Serve) bind(orm *datatable.ORM, value interface{}) string {
+	orm.SqlValues = append(orm.SqlValues, value)
+	return s.Placeholder(len(orm.SqlValues))
 }
 
 func (s *Serve) query(command string, args ...interface{}) (*sql.Rows, error) {
-	if err := s.Connect(); err != nil {
+	conn, err := s.DB()
+	if err != nil {
 		return nil, err
 	}
-	return s.conn.Query(command, args...)
+	rows, err := conn.Query(command, args...)
+	if err != nil {
+		s.Fail(err)
+	}
+	return rows, err
 }
 
 func (s *Serve) exec(command string, args ...interface{}) (sql.Result, error) {
-	if err := s.Connect(); err != nil {
+	conn, err := s.DB()
+	if err != nil {
 		return nil, err
 	}
-	return s.conn.Exec(command, args...)
+	res, err := conn.Exec(command, args...)
+	if err != nil {
+		s.Fail(err)
+	}
+	return res, err
 }
 
 func (s *Serve) dataTable(command string, params ...interface{}) (*datatable.DataTable, error) {
@@ -104,11 +165,13 @@ func (s *Serve) dataSet(command string, params ...interface{}) (*datatable.DataS
 
 func (s *Serve) Select(orm *datatable.ORM) error {
 	orm.SqlCommand.Reset()
+	orm.Order = ""
+	orm.Group = ""
 	orm.SqlCommand.Append("SELECT ")
 	var use bool
 	switch orm.ColumnMode {
 	case 1:
-		for c := range orm.Columns {
+		for _, c := range orm.ColumnNames() {
 			if use {
 				orm.SqlCommand.Append(",")
 			}
@@ -116,7 +179,7 @@ func (s *Serve) Select(orm *datatable.ORM) error {
 			use = true
 		}
 	default:
-		for c := range orm.SqlStructMap {
+		for _, c := range orm.FieldNames() {
 			if orm.ColumnMode == -1 {
 				if util.WhetherToSkip(orm.ColumnMode, orm.Columns, c) {
 					continue
@@ -135,42 +198,67 @@ func (s *Serve) Select(orm *datatable.ORM) error {
 
 func (s *Serve) Count(orm *datatable.ORM) error {
 	orm.SqlCommand.Reset()
+	orm.Order = ""
+	orm.Group = ""
 	orm.SqlCommand.Append(" SELECT count(1) as count FROM ").Append(orm.TableName)
 	return nil
 }
 
 func (s *Serve) Insert(orm *datatable.ORM) error {
+	if orm.Conflict != nil {
+		return errors.New("mssql does not support upsert")
+	}
 	orm.SqlCommand.Reset()
 	orm.SqlCommand.Append(" INSERT INTO ").Append(orm.TableName)
-	fieldStr := "("
-	valueStr := "("
-	for k, v := range orm.SqlStructMap {
-		if v.Tag != "" && strings.Contains(v.Tag, "auto_increment") {
+	var fields []string
+	for _, k := range orm.FieldNames() {
+		if orm.Generated(k) {
 			continue
 		}
 		if util.WhetherToSkip(orm.ColumnMode, orm.Columns, k) {
 			continue
 		}
-		if len(fieldStr) > 1 {
-			fieldStr += ","
-			valueStr += ","
+		fields = append(fields, k)
+	}
+	orm.SqlCommand.Append("(").Append(strings.Join(fields, ",")).Append(")")
+	if pk := s.identity(orm); pk != "" {
+		orm.SqlCommand.Append(" OUTPUT INSERTED.").Append(pk).Append(" ")
+	}
+	orm.SqlCommand.Append("VALUES")
+	for i, row := range orm.Rows() {
+		if i > 0 {
+			orm.SqlCommand.Append(",")
+		}
+		orm.SqlCommand.Append("(")
+		for j, k := range fields {
+			if j > 0 {
+				orm.SqlCommand.Append(",")
+			}
+			orm.SqlCommand.Append(s.bind(orm, row[k].Value()))
 		}
-		fieldStr += k
-		valueStr += "?"
-		orm.SqlValues = append(orm.SqlValues, v.Val)
+		orm.SqlCommand.Append(")")
 	}
-	fieldStr += ")"
-	valueStr += ")"
-	orm.SqlCommand.Append(fieldStr).Append("VALUES").Append(valueStr)
 	return nil
 }
 
+// identity 自增字段名 没有时为空
+// identity returns the auto_increment field, or "" when the struct has none
+func (s *Serve) identity(orm *datatable.ORM) string {
+	for _, k := range orm.FieldNames() {
+		if datatable.AutoIncrement(orm.SqlStructMap[k].Tag) {
+			return k
+		}
+	}
+	return ""
+}
+
 func (s *Serve) Update(orm *datatable.ORM) error {
 	orm.SqlCommand.Reset()
 	orm.SqlCommand.Append(" UPDATE ").Append(orm.TableName).Append(" SET ")
 	var use bool
-	for k, v := range orm.SqlStructMap {
-		if v.Tag != "" && strings.Contains(v.Tag, "auto_increment") {
+	for _, k := range orm.FieldNames() {
+		v := orm.SqlStructMap[k]
+		if datatable.AutoIncrement(v.Tag) {
 			continue
 		}
 		if util.WhetherToSkip(orm.ColumnMode, orm.Columns, k) {
@@ -179,8 +267,7 @@ func (s *Serve) Update(orm *datatable.ORM) error {
 		if use {
 			orm.SqlCommand.Append(",")
 		}
-		orm.SqlCommand.Append(k).Append("=?")
-		orm.SqlValues = append(orm.SqlValues, v.Val)
+		orm.SqlCommand.Append(k).Append("=").Append(s.bind(orm, v.Val))
 		use = true
 	}
 	return nil
@@ -199,13 +286,15 @@ func (s *Serve) Where(orm *datatable.ORM, wheres ...string) error {
 	orm.SqlCommand.Append(" WHERE")
 	for i, w := range wheres {
 		if util.Verify(w) {
-			return errors.New("verification failed")
+			return datatable.ErrVerification
 		}
 		field, andor := util.GetFieldName(w)
 		if v, ok := orm.SqlStructMap[field]; ok {
-			orm.SqlValues = append(orm.SqlValues, v.Val)
+			if strings.Contains(w, "?") {
+				w = strings.Replace(w, "?", s.bind(orm, v.Val), 1)
+			}
 		} else {
-			return errors.New("the query condition does not exist")
+			return datatable.ErrUnknownCondition
 		}
 		if i > 0 {
 			switch andor {
@@ -220,42 +309,169 @@ func (s *Serve) Where(orm *datatable.ORM, wheres ...string) error {
 }
 
 func (s *Serve) OrderBy(orm *datatable.ORM, field string) error {
-	s.step = 5
+	orm.Order = field
 	orm.SqlCommand.Append(" ORDER BY ").Append(field)
 	return nil
 }
 
 func (s *Serve) GroupBy(orm *datatable.ORM, field string) error {
+	orm.Group = field
 	orm.SqlCommand.Append(" GROUP BY ").Append(field)
 	return nil
 }
 
-// Limit Only supports version SQL SERVER 2012 and above
+// Limit SQL Server 2012(11)起使用OFFSET FETCH 更早版本(Options.Version)使用TOP或ROW_NUMBER()
+// 未调用OrderBy时按主键排序(分组时按首个分组字段) 保证分页稳定
+// Limit pages with OFFSET ... FETCH from SQL Server 2012 (version 11) on, and
+// with TOP or ROW_NUMBER() when Options.Version is older. Without an OrderBy
+// the rows are ordered by the primary key, or by the first grouped column
+// after a GroupBy, so that pages are stable.
 func (s *Serve) Limit(orm *datatable.ORM, limit int, offset ...int) error {
-	if s.step != 5 {
-		for k := range orm.SqlStructMap {
-			_ = s.OrderBy(orm, k)
-			break
-		}
+	if orm.Order == "" {
+		_ = s.OrderBy(orm, s.orderKey(orm))
+	}
+	if s.Options.Version > 0 && s.Options.Version < 11 {
+		return s.legacyLimit(orm, limit, offset...)
 	}
 	if len(offset) > 0 {
-		orm.SqlCommand.Append(" OFFSET ").AppendInt(offset[0]).Append(" ROWS FETCH NEXT ").AppendInt(limit).Append(" ROWS ONLY")
+		orm.SqlCommand.Append(" OFFSET ").Append(s.bind(orm, offset[0])).Append(" ROWS FETCH NEXT ").Append(s.bind(orm, limit)).Append(" ROWS ONLY")
 	} else {
-		orm.SqlCommand.Append(" OFFSET 0 ROWS FETCH NEXT ").AppendInt(limit).Append(" ROWS ONLY")
+		orm.SqlCommand.Append(" OFFSET 0 ROWS FETCH NEXT ").Append(s.bind(orm, limit)).Append(" ROWS ONLY")
 	}
 	return nil
 }
 
+// orderKey 分页的默认排序 首个分组字段 自增字段 主键 首个字段 都没有时不排序
+// orderKey picks the default paging order: the first grouped column, since
+// SQL Server rejects ordering by anything else after GROUP BY, then the
+// identity, the primary key, the first field, or no order at all
+func (s *Serve) orderKey(orm *datatable.ORM) string {
+	if orm.Group != "" {
+		if k := strings.TrimSpace(strings.Split(orm.Group, ",")[0]); k != "" {
+			return k
+		}
+		return "(SELECT NULL)"
+	}
+	if k := s.identity(orm); k != "" {
+		return k
+	}
+	names := orm.FieldNames()
+	for _, k := range names {
+		if strings.Contains(orm.SqlStructMap[k].Tag, "primary key") {
+			return k
+		}
+	}
+	if len(names) > 0 {
+		return names[0]
+	}
+	return "(SELECT NULL)"
+}
+
+// legacyLimit SQL Server 2012之前的分页 无偏移时用TOP 否则用ROW_NUMBER()子查询(多出gsql_row列)
+// legacyLimit pages for SQL Server before 2012: TOP without an offset,
+// otherwise a ROW_NUMBER() subquery whose rows carry an extra gsql_row column
+func (s *Serve) legacyLimit(orm *datatable.ORM, limit int, offset ...int) error {
+	command := orm.SqlCommand.ToString()
+	upper := strings.ToUpper(command)
+	sel := strings.Index(upper, "SELECT ")
+	from := strings.Index(upper, " FROM ")
+	if sel < 0 || from < sel {
+		return errors.New("limit needs a SELECT ... FROM statement")
+	}
+	orm.SqlCommand.Reset()
+	if len(offset) == 0 || offset[0] <= 0 {
+		command = strings.TrimSuffix(command, " ORDER BY (SELECT NULL)")
+		sel += len("SELECT ")
+		orm.SqlCommand.Append(command[:sel]).Append("TOP (").Append(s.bind(orm, limit)).Append(") ").Append(command[sel:])
+		return nil
+	}
+	command = strings.TrimSuffix(command, " ORDER BY "+orm.Order)
+	orm.SqlCommand.Append("SELECT * FROM (").Append(command[:from]).
+		Append(",ROW_NUMBER() OVER (ORDER BY ").Append(orm.Order).Append(") AS gsql_row").Append(command[from:]).
+		Append(") AS gsql_page WHERE gsql_row BETWEEN ").Append(s.bind(orm, offset[0]+1)).
+		Append(" AND ").Append(s.bind(orm, offset[0]+limit)).Append(" ORDER BY gsql_row")
+	return nil
+}
+
+// Call 生成EXEC语句 返回值作为最后一个结果集读取 输出参数(sql.Out)须命名
+// Call builds an EXEC batch that selects the return status as its last
+// result set; output parameters (sql.Out) must be named
+func (s *Serve) Call(orm *datatable.ORM, proc string, params ...sql.NamedArg) error {
+	orm.SqlCommand.Reset()
+	orm.SqlCommand.Append("DECLARE @gsql_status int; EXEC @gsql_status = ").Append(proc)
+	for i, p := range params {
+		if i > 0 {
+			orm.SqlCommand.Append(",")
+		}
+		orm.SqlCommand.Append(" ")
+		_, out := p.Value.(sql.Out)
+		if p.Name == "" {
+			if out {
+				return errOutName
+			}
+			orm.SqlCommand.Append(s.bind(orm, p.Value))
+			continue
+		}
+		orm.SqlValues = append(orm.SqlValues, p)
+		orm.SqlCommand.Append("@").Append(p.Name).Append("=@").Append(p.Name)
+		if out {
+			orm.SqlCommand.Append(" OUTPUT")
+		}
+	}
+	orm.SqlCommand.Append("; SELECT @gsql_status AS gsql_status")
+	orm.Params = params
+	return nil
+}
+
 func (s *Serve) DataSet(orm *datatable.ORM) (*datatable.DataSet, error) {
-	return s.dataSet(orm.SqlCommand.String(), orm.SqlValues...)
+	ds, err := s.dataSet(orm.SqlCommand.String(), orm.SqlValues...)
+	if err != nil || orm.Mode != datatable.Call {
+		return ds, err
+	}
+	if n := len(ds.Tables); n > 0 {
+		if last := ds.Tables[n-1]; len(last.Columns) == 1 && last.Columns[0].Name == "gsql_status" && last.Count == 1 {
+			ds.Status = util.ToInt(last.Rows[0]["gsql_status"])
+			ds.Tables = ds.Tables[:n-1]
+		}
+	}
+	return ds, nil
 }
 
 func (s *Serve) DataTable(orm *datatable.ORM) (*datatable.DataTable, error) {
-	s.step = 0
 	return s.dataTable(orm.SqlCommand.String(), orm.SqlValues...)
 }
 
 func (s *Serve) Execute(orm *datatable.ORM) (sql.Result, error) {
-	s.step = 0
+	if orm.Mode == datatable.Add && s.identity(orm) != "" {
+		return s.insert(orm.SqlCommand.String(), orm.SqlValues...)
+	}
 	return s.exec(orm.SqlCommand.String(), orm.SqlValues...)
 }
+
+// insert 以查询方式执行带OUTPUT INSERTED的Insert go-mssqldb不支持LastInsertId
+// 新id按升序返回 表上有触发器时OUTPUT需改用OUTPUT INTO 此处不支持
+// insert runs an insert carrying OUTPUT INSERTED as a query, since go-mssqldb
+// does not implement LastInsertId. The ids are returned in ascending order,
+// which is the order identity values are assigned to a multi-row VALUES list.
+// Tables with triggers reject a bare OUTPUT clause.
+func (s *Serve) insert(command string, args ...interface{}) (sql.Result, error) {
+	rows, err := s.query(command, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids datatable.InsertResult
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		s.Fail(err)
+		return nil, err
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gsql
+
+import (
+	"errors"
+	"github.com/BlueStorm001/gsql/datatable"
+	"strconv"
+	"sync"
+)
+
+// MultiResult 多个Serve的合并结果 Errors与serves一一对应
+// MultiResult is the merged result of a query run on several Serves.
+// Errors holds the error of each source, aligned with the serves passed
+// to QueryAll; rows from the sources that succeeded are kept.
+type MultiResult struct {
+	*SqlResult
+	Errors []error
+}
+
+// QueryAll 在多个Serve上并发执行同一查询并合并结果
+// QueryAll runs the query built by build concurrently on every Serve and
+// merges the resulting tables. Count queries are added up.
+func QueryAll(serves []*Serve, build func(s *Serve) *ORM) *MultiResult {
+	results := make([]*SqlResult, len(serves))
+	modes := make([]datatable.UseMode, len(serves))
+	var wg sync.WaitGroup
+	for i, s := range serves {
+		wg.Add(1)
+		go func(i int, s *Serve) {
+			defer wg.Done()
+			orm := build(s)
+			if orm == nil {
+				results[i] = &SqlResult{SqlResult: &datatable.SqlResult{Error: errors.New("no query was built")}}
+				return
+			}
+			if orm.ORM != nil {
+				modes[i] = orm.Mode
+			}
+			results[i] = orm.Execute()
+		}(i, s)
+	}
+	wg.Wait()
+
+	r := &MultiResult{SqlResult: &SqlResult{SqlResult: new(datatable.SqlResult)}, Errors: make([]error, len(serves))}
+	var tables []*datatable.DataTable
+	var count int64
+	var counting bool
+	var failed int
+	for i, result := range results {
+		if result.Error != nil {
+			r.Errors[i] = result.Error
+			failed++
+			continue
+		}
+		if modes[i] == datatable.Count {
+			counting = true
+			count += result.RowsAffected
+		} else if result.DataTable != nil {
+			tables = append(tables, result.DataTable)
+		}
+	}
+	if counting {
+		r.RowsAffected = count
+		r.DataTable = &datatable.DataTable{Columns: []*datatable.Column{{Name: "count"}}, Rows: []map[string]interface{}{{"count": count}}, Count: 1}
+	} else {
+		r.DataTable = datatable.Merge(tables...)
+		r.RowsAffected = int64(r.DataTable.Count)
+	}
+	if failed > 0 {
+		r.Error = errors.New(strconv.Itoa(failed) + " of " + strconv.Itoa(len(serves)) + " sources failed")
+	}
+	return r
+}
+
+// OrderBy 对合并结果排序 例如 "id desc,name"
+// OrderBy sorts the merged rows, e.g. "id desc,name"
+func (r *MultiResult) OrderBy(query string) *MultiResult {
+	r.DataTable = r.DataTable.OrderBy(query)
+	return r
+}
+
+// Limit 对合并结果分页
+// Limit keeps at most limit merged rows starting at offset
+func (r *MultiResult) Limit(limit int, offset ...int) *MultiResult {
+	r.DataTable = r.DataTable.Limit(limit, offset...)
+	r.RowsAffected = int64(r.DataTable.Count)
+	return r
+}
+
+// GroupBy 对各来源的分组结果重新分组并对sums列求和 例如 GroupBy("name", "count")
+// GroupBy regroups per-source aggregates by query and adds up the sums columns, e.g. GroupBy("name", "count")
+func (r *MultiResult) GroupBy(query string, sums ...string) *MultiResult {
+	r.DataTable = r.DataTable.Sum(query, sums...)
+	r.RowsAffected = int64(r.DataTable.Count)
+	return r
+}
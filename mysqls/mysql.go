@@ -21,65 +21,134 @@
 package mysqls
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"github.com/BlueStorm001/gsql/datatable"
 	"github.com/BlueStorm001/gsql/util"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
 	"strings"
 )
 
+var errOutName = errors.New("output parameters must be named")
+
 type Serve struct {
 	*datatable.Serve
-	conn *sql.DB
-	//drive func(s *Serve) (db *sql.DB, err error)
+	*datatable.Conn
 }
 
-func (s *Serve) Connect() error {
-	var err error
-	if s == nil || s.conn == nil {
-		err = errors.New("conn is null")
-	} else {
-		err = s.conn.Ping()
+// New 创建方言 连接由Serve的字段或驱动函数打开
+// New creates the dialect for serve, connecting as serve's fields or drive function say
+func New(serve *datatable.Serve) *Serve {
+	s := &Serve{Serve: serve}
+	s.Conn = datatable.NewConn(serve, "mysql", s.DSN, nil)
+	return s
+}
+
+// DSN 由Host Port Auth Database Options生成go-sql-driver/mysql连接串
+// DSN builds a go-sql-driver/mysql data source name from Host, Port, Auth, Database and Options
+func (s *Serve) DSN() string {
+	port := s.Port
+	if port == 0 {
+		port = 3306
 	}
-	if err == nil {
-		return nil
+	var user, pass string
+	if s.Auth != nil {
+		user, pass = s.Auth.User, s.Auth.Pass
 	}
-	switch s.DriveMode {
-	case 1:
-		s.conn, s.Error = s.DriveServe(s.Serve)
-	case 2:
-		s.conn, s.Error = s.Drive()
-	default:
-		s.Error = errors.New("drive mode error")
+	params := url.Values{}
+	if s.Options.Charset != "" {
+		params.Set("charset", s.Options.Charset)
+	}
+	if s.Options.ParseTime {
+		params.Set("parseTime", "true")
+	}
+	if s.Options.TLS != "" {
+		params.Set("tls", s.Options.TLS)
+	}
+	if s.Options.ReadTimeout > 0 {
+		params.Set("readTimeout", s.Options.ReadTimeout.String())
 	}
-	if s.Error != nil {
-		return s.Error
+	if s.Options.WriteTimeout > 0 {
+		params.Set("writeTimeout", s.Options.WriteTimeout.String())
 	}
-	return s.conn.Ping()
+	for k, v := range s.Options.Settings {
+		params.Set(k, v)
+	}
+	dsn := user + ":" + pass + "@tcp(" + net.JoinHostPort(s.Host, strconv.Itoa(port)) + ")/" + s.Database
+	if len(params) > 0 {
+		dsn += "?" + params.Encode()
+	}
+	return dsn
 }
 
-func (s *Serve) Close() error {
-	var err error
-	if s.conn != nil {
-		if err = s.conn.Close(); err == nil {
-			s.conn = nil
+// Retryable 死锁(1213) 锁等待超时(1205) 连接断开(2006,2013)
+// Retryable reports deadlocks (1213), lock wait timeouts (1205) and lost connections (2006, 2013)
+func (s *Serve) Retryable(err error) bool {
+	if datatable.IsConnError(err) {
+		return true
+	}
+	if code, ok := datatable.ErrorNumber(err); ok {
+		switch code {
+		case 1213, 1205, 2006, 2013:
+			return true
 		}
 	}
-	return err
+	return false
+}
+
+// Classify 重复键(1062,1586) 死锁(1213)
+// Classify maps duplicate keys (1062, 1586) and deadlocks (1213) to their sentinel errors
+func (s *Serve) Classify(err error) error {
+	if code, ok := datatable.ErrorNumber(err); ok {
+		switch code {
+		case 1062, 1586:
+			return datatable.ErrDuplicateKey
+		case 1213:
+			return datatable.ErrDeadlock
+		}
+	}
+	return nil
+}
+
+// Placeholder 第n个参数的占位符
+// Placeholder returns the placeholder for the n-th argument, counting from 1
+func (s *Serve) Placeholder(n int) string {
+	return "?"
+}
+
+// bind 追加参数并返回占位符
+// bind appends value to the arguments and returns its placeholder
+func (s *Serve) bind(orm *datatable.ORM, value interface{}) string {
+	orm.SqlValues = append(orm.SqlValues, value)
+	return s.Placeholder(len(orm.SqlValues))
 }
 
 func (s *Serve) query(command string, args ...interface{}) (*sql.Rows, error) {
-	if err := s.Connect(); err != nil {
+	conn, err := s.DB()
+	if err != nil {
 		return nil, err
 	}
-	return s.conn.Query(command, args...)
+	rows, err := conn.Query(command, args...)
+	if err != nil {
+		s.Fail(err)
+	}
+	return rows, err
 }
 
 func (s *Serve) exec(command string, args ...interface{}) (sql.Result, error) {
-	if err := s.Connect(); err != nil {
+	conn, err := s.DB()
+	if err != nil {
 		return nil, err
 	}
-	return s.conn.Exec(command, args...)
+	res, err := conn.Exec(command, args...)
+	if err != nil {
+		s.Fail(err)
+	}
+	return res, err
 }
 
 func (s *Serve) dataTable(command string, params ...interface{}) (*datatable.DataTable, error) {
@@ -108,7 +177,7 @@ func (s *Serve) Select(orm *datatable.ORM) error {
 	var use bool
 	switch orm.ColumnMode {
 	case 1:
-		for c := range orm.Columns {
+		for _, c := range orm.ColumnNames() {
 			if use {
 				orm.SqlCommand.Append(",")
 			}
@@ -116,7 +185,7 @@ func (s *Serve) Select(orm *datatable.ORM) error {
 			use = true
 		}
 	default:
-		for c := range orm.SqlStructMap {
+		for _, c := range orm.FieldNames() {
 			if orm.ColumnMode == -1 {
 				if util.WhetherToSkip(orm.ColumnMode, orm.Columns, c) {
 					continue
@@ -142,26 +211,40 @@ func (s *Serve) Count(orm *datatable.ORM) error {
 func (s *Serve) Insert(orm *datatable.ORM) error {
 	orm.SqlCommand.Reset()
 	orm.SqlCommand.Append(" INSERT INTO ").Append(orm.TableName)
-	fieldStr := "("
-	valueStr := "("
-	for k, v := range orm.SqlStructMap {
-		if v.Tag != "" && strings.Contains(v.Tag, "auto_increment") {
+	var fields []string
+	for _, k := range orm.FieldNames() {
+		if orm.Generated(k) {
 			continue
 		}
 		if util.WhetherToSkip(orm.ColumnMode, orm.Columns, k) {
 			continue
 		}
-		if len(fieldStr) > 1 {
-			fieldStr += ","
-			valueStr += ","
+		fields = append(fields, k)
+	}
+	orm.SqlCommand.Append("(").Append(strings.Join(fields, ",")).Append(")")
+	orm.SqlCommand.Append("VALUES")
+	for i, row := range orm.Rows() {
+		if i > 0 {
+			orm.SqlCommand.Append(",")
+		}
+		orm.SqlCommand.Append("(")
+		for j, k := range fields {
+			if j > 0 {
+				orm.SqlCommand.Append(",")
+			}
+			orm.SqlCommand.Append(s.bind(orm, row[k].Value()))
+		}
+		orm.SqlCommand.Append(")")
+	}
+	if orm.Conflict != nil {
+		orm.SqlCommand.Append(" ON DUPLICATE KEY UPDATE ")
+		for i, k := range fields {
+			if i > 0 {
+				orm.SqlCommand.Append(",")
+			}
+			orm.SqlCommand.Append(k).Append("=VALUES(").Append(k).Append(")")
 		}
-		fieldStr += k
-		valueStr += "?"
-		orm.SqlValues = append(orm.SqlValues, v.Val)
 	}
-	fieldStr += ")"
-	valueStr += ")"
-	orm.SqlCommand.Append(fieldStr).Append("VALUES").Append(valueStr)
 	return nil
 }
 
@@ -169,8 +252,9 @@ func (s *Serve) Update(orm *datatable.ORM) error {
 	orm.SqlCommand.Reset()
 	orm.SqlCommand.Append(" UPDATE ").Append(orm.TableName).Append(" SET ")
 	var use bool
-	for k, v := range orm.SqlStructMap {
-		if v.Tag != "" && strings.Contains(v.Tag, "auto_increment") {
+	for _, k := range orm.FieldNames() {
+		v := orm.SqlStructMap[k]
+		if datatable.AutoIncrement(v.Tag) {
 			continue
 		}
 		if util.WhetherToSkip(orm.ColumnMode, orm.Columns, k) {
@@ -179,8 +263,7 @@ func (s *Serve) Update(orm *datatable.ORM) error {
 		if use {
 			orm.SqlCommand.Append(",")
 		}
-		orm.SqlCommand.Append(k).Append("=?")
-		orm.SqlValues = append(orm.SqlValues, v.Val)
+		orm.SqlCommand.Append(k).Append("=").Append(s.bind(orm, v.Val))
 		use = true
 	}
 	return nil
@@ -199,13 +282,15 @@ func (s *Serve) Where(orm *datatable.ORM, wheres ...string) error {
 	orm.SqlCommand.Append(" WHERE")
 	for i, w := range wheres {
 		if util.Verify(w) {
-			return errors.New("verification failed")
+			return datatable.ErrVerification
 		}
 		field, andor := util.GetFieldName(w)
 		if v, ok := orm.SqlStructMap[field]; ok {
-			orm.SqlValues = append(orm.SqlValues, v.Val)
+			if strings.Contains(w, "?") {
+				w = strings.Replace(w, "?", s.bind(orm, v.Val), 1)
+			}
 		} else {
-			return errors.New("the query condition does not exist")
+			return datatable.ErrUnknownCondition
 		}
 		if i > 0 {
 			switch andor {
@@ -231,14 +316,99 @@ func (s *Serve) GroupBy(orm *datatable.ORM, field string) error {
 
 func (s *Serve) Limit(orm *datatable.ORM, limit int, offset ...int) error {
 	if len(offset) > 0 {
-		orm.SqlCommand.Append(" LIMIT ").AppendInt(offset[0]).Append(",").AppendInt(limit)
+		orm.SqlCommand.Append(" LIMIT ").Append(s.bind(orm, offset[0])).Append(",").Append(s.bind(orm, limit))
 	} else {
-		orm.SqlCommand.Append(" LIMIT ").AppendInt(limit)
+		orm.SqlCommand.Append(" LIMIT ").Append(s.bind(orm, limit))
 	}
 	return nil
 }
 
+// Call 生成CALL语句 输出参数(sql.Out)以会话变量@gsql_<name>传递
+// Call builds a CALL statement; output parameters (sql.Out) must be named and
+// are passed through the session variables @gsql_<name>, see call
+func (s *Serve) Call(orm *datatable.ORM, proc string, params ...sql.NamedArg) error {
+	orm.SqlCommand.Reset()
+	orm.SqlCommand.Append("CALL ").Append(proc).Append("(")
+	for i, p := range params {
+		if i > 0 {
+			orm.SqlCommand.Append(",")
+		}
+		if _, ok := p.Value.(sql.Out); ok {
+			if p.Name == "" {
+				return errOutName
+			}
+			orm.SqlCommand.Append("@gsql_").Append(p.Name)
+		} else {
+			orm.SqlCommand.Append(s.bind(orm, p.Value))
+		}
+	}
+	orm.SqlCommand.Append(")")
+	orm.Params = params
+	return nil
+}
+
+// call 在同一连接上设置INOUT变量 执行CALL 再读取输出变量
+// call runs the procedure on one connection: it sets the INOUT variables,
+// reads every result set and then selects the output variables into their Dest
+func (s *Serve) call(orm *datatable.ORM) (*datatable.DataSet, error) {
+	var outs []sql.NamedArg
+	for _, p := range orm.Params {
+		if _, ok := p.Value.(sql.Out); ok {
+			outs = append(outs, p)
+		}
+	}
+	if len(outs) == 0 {
+		return s.dataSet(orm.SqlCommand.String(), orm.SqlValues...)
+	}
+	db, err := s.DB()
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		s.Fail(err)
+		return nil, err
+	}
+	defer conn.Close()
+	command := "SELECT "
+	dest := make([]interface{}, len(outs))
+	for i, p := range outs {
+		out := p.Value.(sql.Out)
+		if out.In {
+			if _, err = conn.ExecContext(ctx, "SET @gsql_"+p.Name+"=?", reflect.ValueOf(out.Dest).Elem().Interface()); err != nil {
+				s.Fail(err)
+				return nil, err
+			}
+		}
+		if i > 0 {
+			command += ","
+		}
+		command += "@gsql_" + p.Name
+		dest[i] = out.Dest
+	}
+	rows, err := conn.QueryContext(ctx, orm.SqlCommand.String(), orm.SqlValues...)
+	if err != nil {
+		s.Fail(err)
+		return nil, err
+	}
+	sr := datatable.SqlRows{Rows: rows}
+	ds, err := sr.GetDataSet()
+	_ = rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err = conn.QueryRowContext(ctx, command).Scan(dest...); err != nil {
+		s.Fail(err)
+		return nil, err
+	}
+	return ds, nil
+}
+
 func (s *Serve) DataSet(orm *datatable.ORM) (*datatable.DataSet, error) {
+	if orm.Mode == datatable.Call {
+		return s.call(orm)
+	}
 	return s.dataSet(orm.SqlCommand.String(), orm.SqlValues...)
 }
 
@@ -0,0 +1,643 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pgsqls
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/BlueStorm001/gsql/datatable"
+	"github.com/BlueStorm001/gsql/util"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type Serve struct {
+	*datatable.Serve
+	*datatable.Conn
+}
+
+// New 创建方言 连接由Serve的字段或驱动函数打开
+// New creates the dialect for serve, connecting as serve's fields or drive function say
+func New(serve *datatable.Serve) *Serve {
+	s := &Serve{Serve: serve}
+	s.Conn = datatable.NewConn(serve, "postgres", s.DSN, nil)
+	return s
+}
+
+// DSN 由Host Port Auth Database Options生成lib/pq(pgx)连接串 TLS对应sslmode
+// DSN builds a lib/pq (or pgx) URL from Host, Port, Auth, Database and Options; TLS maps to sslmode
+func (s *Serve) DSN() string {
+	port := s.Port
+	if port == 0 {
+		port = 5432
+	}
+	u := &url.URL{Scheme: "postgres", Host: net.JoinHostPort(s.Host, strconv.Itoa(port)), Path: "/" + s.Database}
+	if s.Auth != nil {
+		u.User = url.UserPassword(s.Auth.User, s.Auth.Pass)
+	}
+	params := url.Values{}
+	switch s.Options.TLS {
+	case "":
+	case "false":
+		params.Set("sslmode", "disable")
+	case "true":
+		params.Set("sslmode", "verify-full")
+	case "skip-verify":
+		params.Set("sslmode", "require")
+	default:
+		params.Set("sslmode", s.Options.TLS)
+	}
+	for k, v := range s.Options.Settings {
+		params.Set(k, v)
+	}
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
+// fail 连接类错误及SQLSTATE 08(连接异常)标记连接断开
+// fail marks the connection broken on connection errors and SQLSTATE class 08 (connection exception)
+func (s *Serve) fail(err error) {
+	if strings.HasPrefix(sqlState(err), "08") {
+		s.MarkBroken()
+		return
+	}
+	s.Fail(err)
+}
+
+// sqlState 返回SQLSTATE错误码 支持SQLState()方法(lib/pq, pgx)及字符串Code字段
+// sqlState extracts the SQLSTATE of a driver error, via a SQLState method (lib/pq, pgx) or a string Code field
+func sqlState(err error) string {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if e, ok := err.(interface{ SQLState() string }); ok {
+			return e.SQLState()
+		}
+		v := reflect.ValueOf(err)
+		if v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+		if v.Kind() == reflect.Struct {
+			if f := v.FieldByName("Code"); f.Kind() == reflect.String {
+				return f.String()
+			}
+		}
+	}
+	return ""
+}
+
+// Retryable 序列化失败(40001) 死锁(40P01) 锁不可用(55P03) 连接异常(08xxx)
+// Retryable reports serialization failures (40001), deadlocks (40P01),
+// unavailable locks (55P03) and connection exceptions (08xxx)
+func (s *Serve) Retryable(err error) bool {
+	if datatable.IsConnError(err) {
+		return true
+	}
+	switch code := sqlState(err); {
+	case code == "40001", code == "40P01", code == "55P03":
+		return true
+	case strings.HasPrefix(code, "08"):
+		return true
+	}
+	return false
+}
+
+// Classify 唯一约束(23505) 死锁(40P01)
+// Classify maps unique violations (23505) and deadlocks (40P01) to their sentinel errors
+func (s *Serve) Classify(err error) error {
+	switch sqlState(err) {
+	case "23505":
+		return datatable.ErrDuplicateKey
+	case "40P01":
+		return datatable.ErrDeadlock
+	}
+	return nil
+}
+
+// Placeholder 第n个参数的占位符 $1 $2...
+// Placeholder returns the placeholder for the n-th argument, counting from 1: $1, $2...
+func (s *Serve) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// bind 追加参数并返回占位符
+// bind appends value to the arguments and returns its placeholder
+func (s *Serve) bind(orm *datatable.ORM, value interface{}) string {
+	orm.SqlValues = append(orm.SqlValues, value)
+	return s.Placeholder(len(orm.SqlValues))
+}
+
+// quote 为标识符加双引号 schema.table逐段处理 表达式和*保持原样
+// quote double-quotes an identifier, part by part for schema.table; expressions and * are left alone
+func quote(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		if part == "" {
+			return name
+		}
+		for _, c := range part {
+			if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+				return name
+			}
+		}
+		parts[i] = `"` + part + `"`
+	}
+	return strings.Join(parts, ".")
+}
+
+// quoteField 为条件中作为完整单词出现的字段加引号
+// quoteField quotes the first whole-word occurrence of field in a condition
+func quoteField(w, field string) string {
+	for from := 0; ; {
+		i := strings.Index(w[from:], field)
+		if i < 0 {
+			return w
+		}
+		i += from
+		j := i + len(field)
+		if (i == 0 || !isIdent(w[i-1])) && (j == len(w) || !isIdent(w[j])) {
+			return w[:i] + quote(field) + w[j:]
+		}
+		from = j
+	}
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || c == '"' || c == '$' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// quoteList 为"a desc,b"形式的字段列表加引号
+// quoteList quotes the field of every item of a list such as "a desc,b"
+func quoteList(fields string) string {
+	items := strings.Split(fields, ",")
+	for i, item := range items {
+		item = strings.TrimSpace(item)
+		if sp := strings.IndexByte(item, ' '); sp > 0 {
+			items[i] = quote(item[:sp]) + item[sp:]
+		} else {
+			items[i] = quote(item)
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// convert PostgreSQL类型转换 numeric jsonb uuid 以及一维数组
+// convert maps PostgreSQL types returned as text: numeric, json/jsonb, uuid and one-dimensional arrays
+func convert(typ string, value []byte) (interface{}, bool) {
+	switch typ {
+	case "INT2", "INT4", "INT8":
+		return util.ToInt64(value), true
+	case "NUMERIC", "FLOAT4", "FLOAT8":
+		return util.ToFloat64(value), true
+	case "BOOL":
+		return len(value) > 0 && value[0] == 't', true
+	case "JSON", "JSONB":
+		return json.RawMessage(value), true
+	case "UUID":
+		return string(value), true
+	}
+	if !strings.HasPrefix(typ, "_") {
+		return nil, false
+	}
+	items, ok := parseArray(string(value))
+	if !ok {
+		return string(value), true
+	}
+	switch typ {
+	case "_INT2", "_INT4", "_INT8":
+		ints := make([]int64, len(items))
+		for i, item := range items {
+			ints[i] = util.ToInt64(item)
+		}
+		return ints, true
+	case "_NUMERIC", "_FLOAT4", "_FLOAT8":
+		floats := make([]float64, len(items))
+		for i, item := range items {
+			floats[i] = util.ToFloat64(item)
+		}
+		return floats, true
+	case "_BOOL":
+		bools := make([]bool, len(items))
+		for i, item := range items {
+			bools[i] = item == "t"
+		}
+		return bools, true
+	}
+	return items, true
+}
+
+// parseArray 解析一维数组文本 如{1,"a b",NULL} NULL为空字符串 多维数组返回false
+// parseArray splits a one-dimensional array literal such as {1,"a b",NULL};
+// NULL becomes "", and multi-dimensional arrays are rejected
+func parseArray(text string) ([]string, bool) {
+	if len(text) < 2 || text[0] != '{' || text[len(text)-1] != '}' {
+		return nil, false
+	}
+	text = text[1 : len(text)-1]
+	items := []string{}
+	if text == "" {
+		return items, true
+	}
+	builder := util.Builder{}
+	var quoted, wasQuoted bool
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quoted && c == '\\' && i+1 < len(text):
+			i++
+			builder.AppendByte(text[i])
+		case c == '"':
+			quoted = !quoted
+			wasQuoted = true
+		case !quoted && c == '{':
+			return nil, false
+		case !quoted && c == ',':
+			items = append(items, arrayItem(builder.ToString(), wasQuoted))
+			builder.Reset()
+			wasQuoted = false
+		default:
+			builder.AppendByte(c)
+		}
+	}
+	items = append(items, arrayItem(builder.ToString(), wasQuoted))
+	return items, true
+}
+
+func arrayItem(item string, quoted bool) string {
+	if !quoted && item == "NULL" {
+		return ""
+	}
+	return item
+}
+
+func (s *Serve) query(command string, args ...interface{}) (*sql.Rows, error) {
+	conn, err := s.DB()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.Query(command, args...)
+	if err != nil {
+		s.fail(err)
+	}
+	return rows, err
+}
+
+func (s *Serve) exec(command string, args ...interface{}) (sql.Result, error) {
+	conn, err := s.DB()
+	if err != nil {
+		return nil, err
+	}
+	res, err := conn.Exec(command, args...)
+	if err != nil {
+		s.fail(err)
+	}
+	return res, err
+}
+
+func (s *Serve) dataTable(command string, params ...interface{}) (*datatable.DataTable, error) {
+	rows, err := s.query(command, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sr := datatable.SqlRows{Rows: rows, Convert: convert}
+	return sr.GetDataTable()
+}
+
+func (s *Serve) dataSet(command string, params ...interface{}) (*datatable.DataSet, error) {
+	rows, err := s.query(command, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sr := datatable.SqlRows{Rows: rows, Convert: convert}
+	return sr.GetDataSet()
+}
+
+func (s *Serve) Select(orm *datatable.ORM) error {
+	orm.SqlCommand.Reset()
+	orm.SqlCommand.Append("SELECT ")
+	var use bool
+	switch orm.ColumnMode {
+	case 1:
+		for _, c := range orm.ColumnNames() {
+			if use {
+				orm.SqlCommand.Append(",")
+			}
+			orm.SqlCommand.Append(quote(c))
+			use = true
+		}
+	default:
+		for _, c := range orm.FieldNames() {
+			if orm.ColumnMode == -1 {
+				if util.WhetherToSkip(orm.ColumnMode, orm.Columns, c) {
+					continue
+				}
+			}
+			if use {
+				orm.SqlCommand.Append(",")
+			}
+			orm.SqlCommand.Append(quote(c))
+			use = true
+		}
+	}
+	orm.SqlCommand.Append(" FROM ").Append(quote(orm.TableName))
+	return nil
+}
+
+func (s *Serve) Count(orm *datatable.ORM) error {
+	orm.SqlCommand.Reset()
+	orm.SqlCommand.Append(" SELECT count(1) AS count FROM ").Append(quote(orm.TableName))
+	return nil
+}
+
+// Insert 有自增字段时追加RETURNING 以查询方式执行取得新id Conflict不为nil时生成ON CONFLICT
+// Insert appends RETURNING for the identity field, so that Execute can read
+// the new ids, and ON CONFLICT ... DO UPDATE when Conflict is set
+func (s *Serve) Insert(orm *datatable.ORM) error {
+	orm.SqlCommand.Reset()
+	orm.SqlCommand.Append(" INSERT INTO ").Append(quote(orm.TableName))
+	var fields []string
+	for _, k := range orm.FieldNames() {
+		if orm.Generated(k) {
+			continue
+		}
+		if util.WhetherToSkip(orm.ColumnMode, orm.Columns, k) {
+			continue
+		}
+		fields = append(fields, k)
+	}
+	orm.SqlCommand.Append("(")
+	for i, k := range fields {
+		if i > 0 {
+			orm.SqlCommand.Append(",")
+		}
+		orm.SqlCommand.Append(quote(k))
+	}
+	orm.SqlCommand.Append(")VALUES")
+	for i, row := range orm.Rows() {
+		if i > 0 {
+			orm.SqlCommand.Append(",")
+		}
+		orm.SqlCommand.Append("(")
+		for j, k := range fields {
+			if j > 0 {
+				orm.SqlCommand.Append(",")
+			}
+			orm.SqlCommand.Append(s.bind(orm, row[k].Value()))
+		}
+		orm.SqlCommand.Append(")")
+	}
+	if orm.Conflict != nil {
+		s.conflict(orm, fields)
+	}
+	if pk := s.identity(orm); pk != "" {
+		orm.SqlCommand.Append(" RETURNING ").Append(quote(pk))
+	}
+	return nil
+}
+
+// conflict 生成ON CONFLICT 更新冲突列以外的字段 没有可更新字段时DO NOTHING
+// conflict appends ON CONFLICT, updating every field but the conflict columns, or DO NOTHING
+func (s *Serve) conflict(orm *datatable.ORM, fields []string) {
+	orm.SqlCommand.Append(" ON CONFLICT")
+	if len(orm.Conflict) > 0 {
+		orm.SqlCommand.Append(" (")
+		for i, k := range orm.Conflict {
+			if i > 0 {
+				orm.SqlCommand.Append(",")
+			}
+			orm.SqlCommand.Append(quote(k))
+		}
+		orm.SqlCommand.Append(")")
+	}
+	var use bool
+	for _, k := range fields {
+		var target bool
+		for _, c := range orm.Conflict {
+			if c == k {
+				target = true
+			}
+		}
+		if target {
+			continue
+		}
+		if use {
+			orm.SqlCommand.Append(",")
+		} else {
+			orm.SqlCommand.Append(" DO UPDATE SET ")
+		}
+		orm.SqlCommand.Append(quote(k)).Append("=EXCLUDED.").Append(quote(k))
+		use = true
+	}
+	if !use {
+		orm.SqlCommand.Append(" DO NOTHING")
+	}
+}
+
+// identity 自增字段名 没有时为空
+// identity returns the auto_increment field, or "" when the struct has none
+func (s *Serve) identity(orm *datatable.ORM) string {
+	for _, k := range orm.FieldNames() {
+		if datatable.AutoIncrement(orm.SqlStructMap[k].Tag) {
+			return k
+		}
+	}
+	return ""
+}
+
+func (s *Serve) Update(orm *datatable.ORM) error {
+	orm.SqlCommand.Reset()
+	orm.SqlCommand.Append(" UPDATE ").Append(quote(orm.TableName)).Append(" SET ")
+	var use bool
+	for _, k := range orm.FieldNames() {
+		v := orm.SqlStructMap[k]
+		if datatable.AutoIncrement(v.Tag) {
+			continue
+		}
+		if util.WhetherToSkip(orm.ColumnMode, orm.Columns, k) {
+			continue
+		}
+		if use {
+			orm.SqlCommand.Append(",")
+		}
+		orm.SqlCommand.Append(quote(k)).Append("=").Append(s.bind(orm, v.Val))
+		use = true
+	}
+	return nil
+}
+
+func (s *Serve) Delete(orm *datatable.ORM) error {
+	orm.SqlCommand.Reset()
+	orm.SqlCommand.Append(" DELETE FROM ").Append(quote(orm.TableName)).Append(" ")
+	return nil
+}
+
+func (s *Serve) Where(orm *datatable.ORM, wheres ...string) error {
+	if len(wheres) == 0 {
+		return nil
+	}
+	orm.SqlCommand.Append(" WHERE")
+	for i, w := range wheres {
+		if util.Verify(w) {
+			return datatable.ErrVerification
+		}
+		field, andor := util.GetFieldName(w)
+		if v, ok := orm.SqlStructMap[field]; ok {
+			if strings.Contains(w, "?") {
+				w = strings.Replace(w, "?", s.bind(orm, v.Val), 1)
+			}
+			w = quoteField(w, field)
+		} else {
+			return datatable.ErrUnknownCondition
+		}
+		if i > 0 {
+			switch andor {
+			case "and", "or":
+			default:
+				orm.SqlCommand.Append(" AND")
+			}
+		}
+		orm.SqlCommand.Append(" ").Append(w)
+	}
+	return nil
+}
+
+func (s *Serve) OrderBy(orm *datatable.ORM, field string) error {
+	orm.SqlCommand.Append(" ORDER BY ").Append(quoteList(field))
+	return nil
+}
+
+func (s *Serve) GroupBy(orm *datatable.ORM, field string) error {
+	orm.SqlCommand.Append(" GROUP BY ").Append(quoteList(field))
+	return nil
+}
+
+func (s *Serve) Limit(orm *datatable.ORM, limit int, offset ...int) error {
+	orm.SqlCommand.Append(" LIMIT ").Append(s.bind(orm, limit))
+	if len(offset) > 0 {
+		orm.SqlCommand.Append(" OFFSET ").Append(s.bind(orm, offset[0]))
+	}
+	return nil
+}
+
+// Call 生成CALL语句 输出参数(sql.Out)须命名 以NULL(或INOUT的输入值)传入 由返回行读取
+// Call builds a CALL statement. Output parameters (sql.Out) must be named;
+// they are passed as NULL, or their value when In is set, and read back from
+// the row PostgreSQL returns for them
+func (s *Serve) Call(orm *datatable.ORM, proc string, params ...sql.NamedArg) error {
+	orm.SqlCommand.Reset()
+	orm.SqlCommand.Append("CALL ").Append(proc).Append("(")
+	for i, p := range params {
+		if i > 0 {
+			orm.SqlCommand.Append(",")
+		}
+		value := p.Value
+		if out, ok := value.(sql.Out); ok {
+			if p.Name == "" {
+				return errors.New("output parameters must be named")
+			}
+			value = nil
+			if out.In {
+				value = reflect.ValueOf(out.Dest).Elem().Interface()
+			}
+		}
+		if p.Name != "" {
+			orm.SqlCommand.Append(p.Name).Append("=>")
+		}
+		orm.SqlCommand.Append(s.bind(orm, value))
+	}
+	orm.SqlCommand.Append(")")
+	orm.Params = params
+	return nil
+}
+
+func (s *Serve) DataSet(orm *datatable.ORM) (*datatable.DataSet, error) {
+	ds, err := s.dataSet(orm.SqlCommand.String(), orm.SqlValues...)
+	if err != nil || orm.Mode != datatable.Call || len(ds.Tables) == 0 {
+		return ds, err
+	}
+	last := ds.Tables[len(ds.Tables)-1]
+	var outs bool
+	for _, p := range orm.Params {
+		out, ok := p.Value.(sql.Out)
+		if !ok || last.Count != 1 {
+			continue
+		}
+		if v, ok := last.Rows[0][p.Name]; ok {
+			setOut(out.Dest, v)
+			outs = true
+		}
+	}
+	if outs {
+		ds.Tables = ds.Tables[:len(ds.Tables)-1]
+	}
+	return ds, nil
+}
+
+// setOut 将输出参数的值写入Dest
+// setOut stores an output parameter's value in its Dest pointer
+func setOut(dest, value interface{}) {
+	d := reflect.ValueOf(dest)
+	v := reflect.ValueOf(value)
+	if d.Kind() != reflect.Ptr || d.IsNil() || !v.IsValid() {
+		return
+	}
+	if v.Type().ConvertibleTo(d.Elem().Type()) {
+		d.Elem().Set(v.Convert(d.Elem().Type()))
+	}
+}
+
+func (s *Serve) DataTable(orm *datatable.ORM) (*datatable.DataTable, error) {
+	return s.dataTable(orm.SqlCommand.String(), orm.SqlValues...)
+}
+
+func (s *Serve) Execute(orm *datatable.ORM) (sql.Result, error) {
+	if orm.Mode == datatable.Add && s.identity(orm) != "" {
+		return s.insert(orm.SqlCommand.String(), orm.SqlValues...)
+	}
+	return s.exec(orm.SqlCommand.String(), orm.SqlValues...)
+}
+
+// insert 以查询方式执行带RETURNING的Insert lib/pq不支持LastInsertId
+// insert runs an insert carrying RETURNING as a query, since lib/pq does not implement LastInsertId
+func (s *Serve) insert(command string, args ...interface{}) (sql.Result, error) {
+	rows, err := s.query(command, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids datatable.InsertResult
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		s.fail(err)
+		return nil, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pgsqls
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		typ   string
+		value string
+		want  interface{}
+	}{
+		{"NUMERIC", "12.50", 12.5},
+		{"JSONB", `{"a":1}`, json.RawMessage(`{"a":1}`)},
+		{"UUID", "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"},
+		{"_INT4", "{1,2,3}", []int64{1, 2, 3}},
+		{"_TEXT", `{a,"b,c","d\"e",NULL,"NULL"}`, []string{"a", "b,c", `d"e`, "", "NULL"}},
+		{"_BOOL", "{t,f}", []bool{true, false}},
+		{"_INT4", "{{1,2},{3,4}}", "{{1,2},{3,4}}"},
+	}
+	for _, test := range tests {
+		v, ok := convert(test.typ, []byte(test.value))
+		if !ok || !reflect.DeepEqual(v, test.want) {
+			t.Errorf("%s %s: %#v", test.typ, test.value, v)
+		}
+	}
+	if _, ok := convert("TEXT", []byte("x")); ok {
+		t.Error("TEXT converted")
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gsql
+
+import (
+	"container/list"
+	"context"
+	"github.com/BlueStorm001/gsql/datatable"
+	"github.com/BlueStorm001/gsql/util"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pool ORM对象池 等待者按先后顺序获取
+// pool hands out ORM handles, serving blocked callers in FIFO order
+type pool struct {
+	mu      sync.Mutex
+	idle    []*ORM
+	waiters list.List //chan *ORM
+	busy    map[*ORM]*lease
+	reaping bool
+	size    int
+	nextId  int
+}
+
+// lease 一次借出 gen用于识别同一ORM的不同借出
+// lease is one checkout of an ORM; gen tells apart successive checkouts of the same handle
+type lease struct {
+	gen      uint64
+	deadline time.Time
+	stack    []byte
+}
+
+func newPool(s *Serve, size int) *pool {
+	p := &pool{idle: make([]*ORM, 0, size), busy: make(map[*ORM]*lease, size), size: size}
+	for p.nextId < size {
+		p.nextId++
+		p.idle = append(p.idle, s.newORM(p.nextId))
+	}
+	return p
+}
+
+func (s *Serve) newORM(id int) *ORM {
+	return &ORM{ORM: &datatable.ORM{SqlCommand: util.NewBuilder()}, s: s, Id: id, processLock: new(util.Mutex)}
+}
+
+func (s *Serve) acquire(ctx context.Context) (*ORM, error) {
+	p := s.pool
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		orm := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		s.borrow(orm)
+		p.mu.Unlock()
+		s.trace(orm)
+		return orm, nil
+	}
+	ch := make(chan *ORM, 1)
+	e := p.waiters.PushBack(ch)
+	p.mu.Unlock()
+
+	st := time.Now()
+	atomic.AddInt64(&s.waits, 1)
+	timer := time.NewTimer(time.Second * time.Duration(s.Timeout))
+	defer timer.Stop()
+	var err error
+	select {
+	case orm := <-ch:
+		atomic.AddInt64(&s.waitTime, int64(time.Since(st)))
+		s.trace(orm)
+		return orm, nil
+	case <-timer.C:
+		err = ErrPoolExhausted
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	p.mu.Lock()
+	p.waiters.Remove(e)
+	p.mu.Unlock()
+	atomic.AddInt64(&s.waitTime, int64(time.Since(st)))
+	select {
+	case orm := <-ch: //handed over while giving up
+		s.trace(orm)
+		return orm, nil
+	default:
+	}
+	atomic.AddInt64(&s.timeouts, 1)
+	return nil, err
+}
+
+// borrow 需持有p.mu
+// borrow records a checkout of orm; p.mu must be held
+func (s *Serve) borrow(orm *ORM) {
+	p := s.pool
+	orm.gen++
+	p.busy[orm] = &lease{gen: orm.gen, deadline: time.Now().Add(time.Second * time.Duration(s.Timeout))}
+	if !p.reaping {
+		p.reaping = true
+		go s.reap()
+	}
+}
+
+// trace 调试模式下记录获取者的调用栈
+// trace stores the acquiring stack in debug mode
+func (s *Serve) trace(orm *ORM) {
+	if !s.debug {
+		return
+	}
+	stack := debug.Stack()
+	p := s.pool
+	p.mu.Lock()
+	if l, ok := p.busy[orm]; ok {
+		l.stack = stack
+	}
+	p.mu.Unlock()
+}
+
+// put 交给等待者或放回空闲 池已缩小时丢弃 需持有p.mu
+// put hands orm to the longest waiter or parks it, dropping it if the
+// pool has shrunk below its current population; p.mu must be held
+func (s *Serve) put(orm *ORM) {
+	p := s.pool
+	if len(p.idle)+len(p.busy) >= p.size {
+		return
+	}
+	if e := p.waiters.Front(); e != nil {
+		p.waiters.Remove(e)
+		s.borrow(orm)
+		e.Value.(chan *ORM) <- orm
+		return
+	}
+	p.idle = append(p.idle, orm)
+}
+
+func (s *Serve) release(orm *ORM) {
+	p := s.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if l, ok := p.busy[orm]; !ok || l.gen != orm.gen {
+		return
+	}
+	delete(p.busy, orm)
+	s.put(orm)
+}
+
+// reclaim 回收超时的借出 仅当仍是同一次借出时生效
+// 泄漏的ORM不再复用 由新对象替代 迟到的调用不会影响其他使用者
+// reclaim takes back an overdue checkout if it is still the same one.
+// The leaked ORM is replaced by a fresh handle so a late caller cannot
+// disturb whoever receives the slot next.
+func (s *Serve) reclaim(orm *ORM, gen uint64) {
+	p := s.pool
+	p.mu.Lock()
+	l, ok := p.busy[orm]
+	if !ok || l.gen != gen {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.busy, orm)
+	s.put(s.newORM(orm.Id))
+	p.mu.Unlock()
+	atomic.AddInt64(&s.disposals, 1)
+	if s.debug {
+		s.logf("gsql: ORM %d was not executed within %ds and has been reclaimed, acquired at:\n%s", orm.Id, s.Timeout, l.stack)
+	}
+}
+
+// reap 回收超时未归还的ORM 没有借出对象时退出
+// reap reclaims handles held past the timeout and exits once nothing is checked out
+func (s *Serve) reap() {
+	type checkout struct {
+		orm *ORM
+		gen uint64
+	}
+	p := s.pool
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var overdue []checkout
+	for now := range ticker.C {
+		p.mu.Lock()
+		if len(p.busy) == 0 {
+			p.reaping = false
+			p.mu.Unlock()
+			return
+		}
+		for orm, l := range p.busy {
+			if now.After(l.deadline) {
+				overdue = append(overdue, checkout{orm: orm, gen: l.gen})
+			}
+		}
+		p.mu.Unlock()
+		for _, c := range overdue {
+			s.reclaim(c.orm, c.gen)
+		}
+		overdue = overdue[:0]
+	}
+}
+
+// resize 扩容时立即交给等待者 缩容时先丢弃空闲 借出中的在归还时丢弃
+// resize grows the pool by handing new ORMs to waiters, or shrinks it by
+// dropping idle handles first and in-flight ones as they are released
+func (s *Serve) resize(size int) {
+	p := s.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.size = size
+	for len(p.idle)+len(p.busy) < size {
+		p.nextId++
+		s.put(s.newORM(p.nextId))
+	}
+	for len(p.idle) > 0 && len(p.idle)+len(p.busy) > size {
+		p.idle[len(p.idle)-1] = nil
+		p.idle = p.idle[:len(p.idle)-1]
+	}
+}
+
+func (p *pool) stats() (size, inUse, idle int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size, len(p.busy), len(p.idle)
+}
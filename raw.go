@@ -0,0 +1,104 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gsql
+
+import (
+	"database/sql"
+	"github.com/BlueStorm001/gsql/datatable"
+	"github.com/BlueStorm001/gsql/util"
+	"reflect"
+	"time"
+)
+
+// Query 执行原生查询 :name参数从map或结构体绑定 不做关键字校验
+// Query runs a raw query, binding :name parameters from a map or struct
+// (see GetStruct). The SQL is not checked against the keyword blacklist,
+// so callers must never build it from user input.
+func (s *Serve) Query(command string, params interface{}) *SqlResult {
+	return s.raw(datatable.Get, command, params)
+}
+
+// Exec 执行原生语句 可能不是幂等的 因此不重试
+// Exec runs a raw statement with :name parameters. It may not be idempotent,
+// so like any write not marked Idempotent it is never retried
+func (s *Serve) Exec(command string, params interface{}) *SqlResult {
+	return s.raw(datatable.Exec, command, params)
+}
+
+// Call 执行存储过程 返回按顺序命名为Table Table1...的全部结果集
+// 输出参数以命名的sql.Out传入 MSSQL的返回值在DataSet.Status 不重试
+// Call runs a stored procedure and returns every result set in DataSet,
+// named Table, Table1... in order, with the first also in DataTable.
+// Output parameters are passed as named sql.Out values; the MSSQL return
+// status is in DataSet.Status. Like Exec it is never retried.
+func (s *Serve) Call(proc string, params ...sql.NamedArg) *SqlResult {
+	orm := s.NewStruct("", nil)
+	if orm.Error == nil {
+		orm.processLock.Lock()
+		orm.ST = time.Now()
+		orm.Mode = datatable.Call
+		if util.Verify(proc) {
+			orm.Error = ErrVerification
+		} else {
+			orm.Error = s.ISQL.Call(orm.ORM, proc, params...)
+		}
+	}
+	return orm.run(1)
+}
+
+func (s *Serve) raw(mode datatable.UseMode, command string, params interface{}) *SqlResult {
+	orm := s.NewStruct("", nil)
+	if orm.Error == nil {
+		orm.processLock.Lock()
+		orm.ST = time.Now()
+		orm.Mode = mode
+		var err error
+		var cmd string
+		if !rawParams(params) {
+			orm.Error = ErrParamType
+		} else if cmd, orm.SqlValues, err = datatable.Named(command, GetStruct(params), s.ISQL.Placeholder); err == nil {
+			orm.SqlCommand.Append(cmd)
+		} else {
+			orm.Error = err
+		}
+	}
+	return orm.run(2)
+}
+
+// rawParams 参数是否可由GetStruct读取 nil map[string]interface{} 结构体或非空的结构体指针
+// rawParams reports whether GetStruct can read params: nil, a
+// map[string]interface{}, a struct or a non-nil pointer to one
+func rawParams(params interface{}) bool {
+	if params == nil {
+		return true
+	}
+	if _, ok := params.(map[string]interface{}); ok {
+		return true
+	}
+	v := reflect.ValueOf(params)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return false
+		}
+		v = v.Elem()
+	}
+	return v.Kind() == reflect.Struct
+}
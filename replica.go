@@ -0,0 +1,175 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gsql
+
+import (
+	"database/sql"
+	"github.com/BlueStorm001/gsql/datatable"
+	"sync/atomic"
+	"time"
+)
+
+// Replica 只读从库
+// Replica is a read-only copy of the primary database
+type Replica struct {
+	latency int64 //moving average in nanoseconds
+	ejected int64 //unix nano until which the replica is skipped
+	*datatable.Serve
+}
+
+// Latency 平均查询耗时
+// Latency returns the moving average of the replica's query time
+func (r *Replica) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&r.latency))
+}
+
+func (r *Replica) observe(d time.Duration) {
+	old := atomic.LoadInt64(&r.latency)
+	if old == 0 {
+		atomic.StoreInt64(&r.latency, int64(d))
+	} else {
+		atomic.StoreInt64(&r.latency, old-old/8+int64(d)/8)
+	}
+}
+
+func (r *Replica) available(now time.Time) bool {
+	return atomic.LoadInt64(&r.ejected) < now.UnixNano()
+}
+
+// Balancer 从库选择策略
+// Balancer picks the replica that serves the next read
+type Balancer interface {
+	Pick(replicas []*Replica) *Replica
+}
+
+// RoundRobin 轮询
+// RoundRobin cycles through the replicas in turn
+type RoundRobin struct {
+	next uint64
+}
+
+func (b *RoundRobin) Pick(replicas []*Replica) *Replica {
+	n := atomic.AddUint64(&b.next, 1)
+	return replicas[(n-1)%uint64(len(replicas))]
+}
+
+// LeastLatency 选择平均耗时最低的从库
+// LeastLatency picks the replica with the lowest average query time
+type LeastLatency struct{}
+
+func (LeastLatency) Pick(replicas []*Replica) *Replica {
+	pick := replicas[0]
+	for _, r := range replicas[1:] {
+		if r.Latency() < pick.Latency() {
+			pick = r
+		}
+	}
+	return pick
+}
+
+// AddReplica 添加从库 Select和Count由从库执行
+// AddReplica adds a replica of the same database type; Select and Count are served by replicas
+func (s *Serve) AddReplica(drive func() (db *sql.DB, err error)) *Serve {
+	serve := &datatable.Serve{Database: s.Serve.Database, ConnectMax: s.ConnectMax, Timeout: s.Timeout, Drive: drive, DriveMode: 2}
+	serve.ISQL, serve.Error = newISQL(s.kind, serve)
+	if serve.Error != nil {
+		s.Error = serve.Error
+		return s
+	}
+	s.replicas = append(s.replicas, &Replica{Serve: serve})
+	if s.balancer == nil {
+		s.balancer = &RoundRobin{}
+	}
+	return s
+}
+
+// Balance 设置从库选择策略 连接失败的从库在eject时间内不再使用
+// Balance sets the replica balancer and how long a replica failing Connect is ejected
+func (s *Serve) Balance(balancer Balancer, eject time.Duration) *Serve {
+	s.balancer = balancer
+	s.eject = eject
+	return s
+}
+
+// Replicas 返回所有从库
+// Replicas returns the configured replicas
+func (s *Serve) Replicas() []*Replica {
+	return s.replicas
+}
+
+// UsePrimary 强制查询主库 用于读取刚写入的数据
+// UsePrimary sends the next read to the primary, e.g. to read your own writes
+func (o *ORM) UsePrimary() *ORM {
+	o.primary = true
+	return o
+}
+
+// read 优先由从库执行查询 从库连接失败或连接断开时剔除并重试 全部不可用时使用主库
+// read runs a query on a replica, ejecting replicas that fail to connect or
+// lose the connection and falling back to the primary when none is available
+func (s *Serve) read(o *ORM) (*datatable.DataTable, error) {
+	if !o.primary && len(s.replicas) > 0 {
+		for r := s.replica(); r != nil; r = s.replica() {
+			if err := r.ISQL.Connect(); err != nil {
+				s.ejectReplica(r, err)
+				continue
+			}
+			st := time.Now()
+			dt, err := r.ISQL.DataTable(o.ORM)
+			r.observe(time.Since(st))
+			if datatable.IsConnError(err) {
+				//Connect no longer pings, a dead replica shows up here
+				s.ejectReplica(r, err)
+				continue
+			}
+			return dt, err
+		}
+	}
+	var dt *datatable.DataTable
+	err := s.guard(func() (err error) {
+		dt, err = s.ISQL.DataTable(o.ORM)
+		return
+	})
+	return dt, err
+}
+
+func (s *Serve) ejectReplica(r *Replica, err error) {
+	eject := s.eject
+	if eject <= 0 {
+		eject = 30 * time.Second
+	}
+	atomic.StoreInt64(&r.ejected, time.Now().Add(eject).UnixNano())
+	s.logf("gsql: replica ejected for %s: %v", eject, err)
+}
+
+func (s *Serve) replica() *Replica {
+	now := time.Now()
+	replicas := make([]*Replica, 0, len(s.replicas))
+	for _, r := range s.replicas {
+		if r.available(now) {
+			replicas = append(replicas, r)
+		}
+	}
+	if len(replicas) == 0 {
+		return nil
+	}
+	return s.balancer.Pick(replicas)
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gsql
+
+import (
+	"github.com/BlueStorm001/gsql/datatable"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy 暂时性错误(死锁 连接断开等)的重试策略
+// RetryPolicy retries statements failing with transient errors such as
+// deadlocks or lost connections, backing off exponentially between attempts.
+type RetryPolicy struct {
+	Attempts  int           //maximum number of attempts including the first
+	BaseDelay time.Duration //delay before the first retry, doubled for each further one
+	MaxDelay  time.Duration //upper bound of the delay
+	Jitter    float64       //fraction of the delay that is randomized, 0 to 1
+}
+
+// Retry 设置重试策略 写操作(Insert Update Delete Exec Call)仅在标记Idempotent时重试
+// Retry sets the retry policy. Reads are retried; writes (Insert, Update,
+// Delete, Exec and Call) only when marked Idempotent, since a failed attempt
+// may already have committed.
+func (s *Serve) Retry(policy *RetryPolicy) *Serve {
+	s.retryPolicy = policy
+	return s
+}
+
+// Idempotent 标记语句可安全重复执行
+// Idempotent marks the statement as safe to run more than once, allowing writes to be retried
+func (o *ORM) Idempotent() *ORM {
+	o.idempotent = true
+	return o
+}
+
+// retry 判断是否重试 需要时等待后返回true
+// retry reports whether attempt should be followed by another one, sleeping for the backoff first
+func (s *Serve) retry(o *ORM, err error, attempt int) bool {
+	policy := s.retryPolicy
+	if policy == nil || attempt >= policy.Attempts {
+		return false
+	}
+	if o.Mode != datatable.Get && o.Mode != datatable.Count && !o.idempotent {
+		return false
+	}
+	if !s.ISQL.Retryable(err) {
+		return false
+	}
+	delay := policy.delay(attempt)
+	s.logf("gsql: retry %d/%d in %s: %v", attempt, policy.Attempts-1, delay, err)
+	time.Sleep(delay)
+	return true
+}
+
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 && delay > 0 {
+		spread := time.Duration(float64(delay) * p.Jitter)
+		delay = delay - spread + time.Duration(rand.Int63n(int64(spread)+1))
+	}
+	return delay
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gsql
+
+import (
+	"errors"
+	"fmt"
+	"github.com/BlueStorm001/gsql/util"
+	"hash/crc32"
+)
+
+// ShardRule 分片规则 将分片键的值映射到分片序号
+// ShardRule maps the value of the shard key to a shard number in [0, Shards())
+type ShardRule interface {
+	Shards() int
+	Shard(value interface{}) (int, error)
+}
+
+// ModRule 按数值取模分为n片
+// ModRule shards by the numeric value modulo n
+type ModRule int
+
+func (r ModRule) Shards() int {
+	return int(r)
+}
+
+func (r ModRule) Shard(value interface{}) (int, error) {
+	v := util.ToInt64(value) % int64(r)
+	if v < 0 {
+		v = -v
+	}
+	return int(v), nil
+}
+
+// HashRule 按crc32哈希取模分为n片 适用于字符串
+// HashRule shards by the crc32 of the value modulo n, suitable for strings
+type HashRule int
+
+func (r HashRule) Shards() int {
+	return int(r)
+}
+
+func (r HashRule) Shard(value interface{}) (int, error) {
+	return int(crc32.ChecksumIEEE([]byte(util.ToString(value))) % uint32(r)), nil
+}
+
+// RangeRule 按上界(不含)划分 第i片为 [RangeRule[i-1], RangeRule[i])
+// RangeRule shards by exclusive upper bounds; shard i holds [RangeRule[i-1], RangeRule[i])
+type RangeRule []int64
+
+func (r RangeRule) Shards() int {
+	return len(r)
+}
+
+func (r RangeRule) Shard(value interface{}) (int, error) {
+	v := util.ToInt64(value)
+	for i, bound := range r {
+		if v < bound {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("shard key %d is out of range", v)
+}
+
+// Sharding 分表分库 物理表按顺序平均分布在Serves上
+// 例如 Table "orders_%02d" 64片 两个Serve: orders_00..31 在第一个 orders_32..63 在第二个
+// Sharding splits a logical table into physical tables spread evenly, in
+// order, over Serves. With Table "orders_%02d", 64 shards and two Serves,
+// orders_00..31 live on the first and orders_32..63 on the second.
+type Sharding struct {
+	Table  string //physical table name format
+	Key    string //struct field holding the shard key
+	Rule   ShardRule
+	Serves []*Serve
+}
+
+func NewSharding(table, key string, rule ShardRule, serves ...*Serve) *Sharding {
+	return &Sharding{Table: table, Key: key, Rule: rule, Serves: serves}
+}
+
+// NewStruct 根据结构中分片键的值选择物理表和Serve
+// NewStruct picks the physical table and Serve from the shard key's value in inStruct.
+// Select, Count, Update and Delete must then filter on the shard key.
+func (sh *Sharding) NewStruct(inStruct interface{}) *ORM {
+	if len(sh.Serves) == 0 || sh.Rule == nil || sh.Rule.Shards() <= 0 {
+		return &ORM{Error: errors.New("sharding is not configured")}
+	}
+	field, ok := GetStruct(inStruct)[sh.Key]
+	if !ok {
+		return &ORM{Error: errors.New("shard key " + sh.Key + " does not exist")}
+	}
+	shard, err := sh.Rule.Shard(field.Val)
+	if err != nil {
+		return &ORM{Error: err}
+	}
+	serve := sh.Serves[shard*len(sh.Serves)/sh.Rule.Shards()]
+	orm := serve.NewStruct(fmt.Sprintf(sh.Table, shard), inStruct)
+	if orm.Error == nil {
+		orm.shardKey = sh.Key
+	}
+	return orm
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gsql
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SlowQuery 慢查询记录
+// SlowQuery describes a statement that exceeded the slow query threshold
+type SlowQuery struct {
+	SQL      string        //fingerprint
+	Args     []interface{} //bound values
+	Duration time.Duration //time consuming
+	Caller   string        //file:line
+	Time     time.Time     //execution start time
+}
+
+type slowLog struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	entries   []*SlowQuery
+	next      int
+	full      bool
+	callback  func(q *SlowQuery)
+}
+
+// SlowLog 记录超过阈值的语句 保留最近size条
+// SlowLog records statements slower than threshold, keeping the last size entries
+func (s *Serve) SlowLog(threshold time.Duration, size int, callback ...func(q *SlowQuery)) *Serve {
+	if threshold <= 0 || size <= 0 {
+		s.slow = nil
+		return s
+	}
+	slow := &slowLog{threshold: threshold, entries: make([]*SlowQuery, size)}
+	if len(callback) > 0 {
+		slow.callback = callback[0]
+	}
+	s.slow = slow
+	return s
+}
+
+// SlowQueries 返回最近的慢查询 由旧到新
+// SlowQueries returns the recorded slow queries, oldest first
+func (s *Serve) SlowQueries() []*SlowQuery {
+	slow := s.slow
+	if slow == nil {
+		return nil
+	}
+	slow.mu.Lock()
+	defer slow.mu.Unlock()
+	if !slow.full {
+		return append([]*SlowQuery(nil), slow.entries[:slow.next]...)
+	}
+	queries := make([]*SlowQuery, 0, len(slow.entries))
+	queries = append(queries, slow.entries[slow.next:]...)
+	return append(queries, slow.entries[:slow.next]...)
+}
+
+func (l *slowLog) add(q *SlowQuery) {
+	l.mu.Lock()
+	l.entries[l.next] = q
+	l.next++
+	if l.next == len(l.entries) {
+		l.next = 0
+		l.full = true
+	}
+	l.mu.Unlock()
+	if l.callback != nil {
+		l.callback(q)
+	}
+}
+
+func caller(skip int) string {
+	_, file, line, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return ""
+	}
+	return file + ":" + strconv.Itoa(line)
+}
@@ -0,0 +1,357 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package sqlites
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/BlueStorm001/gsql/datatable"
+	"github.com/BlueStorm001/gsql/util"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type Serve struct {
+	*datatable.Serve
+	*datatable.Conn
+}
+
+// New 创建方言 连接由Serve的字段或驱动函数打开
+// New creates the dialect for serve, connecting as serve's fields or drive function say
+func New(serve *datatable.Serve) *Serve {
+	s := &Serve{Serve: serve}
+	s.Conn = datatable.NewConn(serve, "sqlite3", s.DSN, s.limit)
+	return s
+}
+
+// DSN Database为文件路径或:memory: Settings作为查询参数(如_busy_timeout)
+// DSN builds a file: URI from Database, a file path or :memory:, with
+// Options.Settings as query parameters such as _busy_timeout or _journal_mode
+func (s *Serve) DSN() string {
+	params := url.Values{}
+	for k, v := range s.Options.Settings {
+		params.Set(k, v)
+	}
+	dsn := "file:" + s.Database
+	if len(params) > 0 {
+		dsn += "?" + params.Encode()
+	}
+	return dsn
+}
+
+// limit 设置连接数 :memory:数据库每个连接各自独立 因此只用一个连接
+// limit sets the pool limits; a :memory: database exists once per
+// connection, so it is kept on a single one
+func (s *Serve) limit(conn *sql.DB, n int) {
+	if s.Database == ":memory:" {
+		n = 1
+	}
+	if n > 0 {
+		conn.SetMaxOpenConns(n)
+		conn.SetMaxIdleConns(n)
+	}
+}
+
+// Retryable 数据库忙(5) 表被锁定(6)
+// Retryable reports a busy database (5) and locked tables (6)
+func (s *Serve) Retryable(err error) bool {
+	if datatable.IsConnError(err) {
+		return true
+	}
+	if code, ok := datatable.ErrorNumber(err); ok {
+		switch code & 0xff {
+		case 5, 6:
+			return true
+		}
+	}
+	return false
+}
+
+// Classify 唯一约束与主键冲突
+// Classify maps UNIQUE and PRIMARY KEY constraint failures to ErrDuplicateKey
+func (s *Serve) Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	if msg := err.Error(); strings.Contains(msg, "UNIQUE constraint failed") || strings.Contains(msg, "PRIMARY KEY constraint failed") {
+		return datatable.ErrDuplicateKey
+	}
+	return nil
+}
+
+// Placeholder 第n个参数的占位符
+// Placeholder returns the placeholder for the n-th argument, counting from 1
+func (s *Serve) Placeholder(n int) string {
+	return "?"
+}
+
+// bind 追加参数并返回占位符
+// bind appends value to the arguments and returns its placeholder
+func (s *Serve) bind(orm *datatable.ORM, value interface{}) string {
+	orm.SqlValues = append(orm.SqlValues, value)
+	return s.Placeholder(len(orm.SqlValues))
+}
+
+func (s *Serve) query(command string, args ...interface{}) (*sql.Rows, error) {
+	conn, err := s.DB()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.Query(command, args...)
+	if err != nil {
+		s.Fail(err)
+	}
+	return rows, err
+}
+
+func (s *Serve) exec(command string, args ...interface{}) (sql.Result, error) {
+	conn, err := s.DB()
+	if err != nil {
+		return nil, err
+	}
+	res, err := conn.Exec(command, args...)
+	if err != nil {
+		s.Fail(err)
+	}
+	return res, err
+}
+
+// convert 按声明类型的亲和性转换 INT整数 REAL/FLOA/DOUB浮点 CHAR/CLOB/TEXT字符串 BLOB保持原样
+// 其余(NUMERIC亲和性)尽量转为数字
+// convert applies SQLite type affinity to the declared column type: INT
+// gives int64, REAL/FLOA/DOUB float64, CHAR/CLOB/TEXT string, BLOB or no
+// declared type keeps the bytes, and anything else (NUMERIC affinity) is
+// turned into a number when it looks like one
+func convert(typ string, value []byte) (interface{}, bool) {
+	text := string(value)
+	switch {
+	case strings.Contains(typ, "INT"):
+		if v, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return v, true
+		}
+	case strings.Contains(typ, "CHAR"), strings.Contains(typ, "CLOB"), strings.Contains(typ, "TEXT"):
+		return text, true
+	case typ == "", strings.Contains(typ, "BLOB"):
+		return value, true
+	case strings.Contains(typ, "REAL"), strings.Contains(typ, "FLOA"), strings.Contains(typ, "DOUB"):
+		if v, err := strconv.ParseFloat(text, 64); err == nil {
+			return v, true
+		}
+	case strings.Contains(typ, "BOOL"):
+		return text == "1" || strings.EqualFold(text, "true"), true
+	default:
+		if v, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return v, true
+		}
+		if v, err := strconv.ParseFloat(text, 64); err == nil {
+			return v, true
+		}
+	}
+	return text, true
+}
+
+func (s *Serve) dataTable(command string, params ...interface{}) (*datatable.DataTable, error) {
+	rows, err := s.query(command, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sr := datatable.SqlRows{Rows: rows, Convert: convert}
+	return sr.GetDataTable()
+}
+
+func (s *Serve) dataSet(command string, params ...interface{}) (*datatable.DataSet, error) {
+	rows, err := s.query(command, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sr := datatable.SqlRows{Rows: rows, Convert: convert}
+	return sr.GetDataSet()
+}
+
+func (s *Serve) Select(orm *datatable.ORM) error {
+	orm.SqlCommand.Reset()
+	orm.SqlCommand.Append("SELECT ")
+	var use bool
+	switch orm.ColumnMode {
+	case 1:
+		for _, c := range orm.ColumnNames() {
+			if use {
+				orm.SqlCommand.Append(",")
+			}
+			orm.SqlCommand.Append(c)
+			use = true
+		}
+	default:
+		for _, c := range orm.FieldNames() {
+			if orm.ColumnMode == -1 {
+				if util.WhetherToSkip(orm.ColumnMode, orm.Columns, c) {
+					continue
+				}
+			}
+			if use {
+				orm.SqlCommand.Append(",")
+			}
+			orm.SqlCommand.Append(c)
+			use = true
+		}
+	}
+	orm.SqlCommand.Append(" FROM ").Append(orm.TableName)
+	return nil
+}
+
+func (s *Serve) Count(orm *datatable.ORM) error {
+	orm.SqlCommand.Reset()
+	orm.SqlCommand.Append(" SELECT count(1) AS count FROM ").Append(orm.TableName)
+	return nil
+}
+
+// Insert Conflict不为nil时生成INSERT OR REPLACE 任一唯一约束冲突时替换整行
+// Insert writes INSERT OR REPLACE when Conflict is set, replacing the whole
+// row on any unique constraint; the conflict columns are not used
+func (s *Serve) Insert(orm *datatable.ORM) error {
+	orm.SqlCommand.Reset()
+	if orm.Conflict != nil {
+		orm.SqlCommand.Append(" INSERT OR REPLACE INTO ").Append(orm.TableName)
+	} else {
+		orm.SqlCommand.Append(" INSERT INTO ").Append(orm.TableName)
+	}
+	var fields []string
+	for _, k := range orm.FieldNames() {
+		if orm.Generated(k) {
+			continue
+		}
+		if util.WhetherToSkip(orm.ColumnMode, orm.Columns, k) {
+			continue
+		}
+		fields = append(fields, k)
+	}
+	orm.SqlCommand.Append("(").Append(strings.Join(fields, ",")).Append(")")
+	orm.SqlCommand.Append("VALUES")
+	for i, row := range orm.Rows() {
+		if i > 0 {
+			orm.SqlCommand.Append(",")
+		}
+		orm.SqlCommand.Append("(")
+		for j, k := range fields {
+			if j > 0 {
+				orm.SqlCommand.Append(",")
+			}
+			orm.SqlCommand.Append(s.bind(orm, row[k].Value()))
+		}
+		orm.SqlCommand.Append(")")
+	}
+	return nil
+}
+
+func (s *Serve) Update(orm *datatable.ORM) error {
+	orm.SqlCommand.Reset()
+	orm.SqlCommand.Append(" UPDATE ").Append(orm.TableName).Append(" SET ")
+	var use bool
+	for _, k := range orm.FieldNames() {
+		v := orm.SqlStructMap[k]
+		if datatable.AutoIncrement(v.Tag) {
+			continue
+		}
+		if util.WhetherToSkip(orm.ColumnMode, orm.Columns, k) {
+			continue
+		}
+		if use {
+			orm.SqlCommand.Append(",")
+		}
+		orm.SqlCommand.Append(k).Append("=").Append(s.bind(orm, v.Val))
+		use = true
+	}
+	return nil
+}
+
+func (s *Serve) Delete(orm *datatable.ORM) error {
+	orm.SqlCommand.Reset()
+	orm.SqlCommand.Append(" DELETE FROM ").Append(orm.TableName).Append(" ")
+	return nil
+}
+
+func (s *Serve) Where(orm *datatable.ORM, wheres ...string) error {
+	if len(wheres) == 0 {
+		return nil
+	}
+	orm.SqlCommand.Append(" WHERE")
+	for i, w := range wheres {
+		if util.Verify(w) {
+			return datatable.ErrVerification
+		}
+		field, andor := util.GetFieldName(w)
+		if v, ok := orm.SqlStructMap[field]; ok {
+			if strings.Contains(w, "?") {
+				w = strings.Replace(w, "?", s.bind(orm, v.Val), 1)
+			}
+		} else {
+			return datatable.ErrUnknownCondition
+		}
+		if i > 0 {
+			switch andor {
+			case "and", "or":
+			default:
+				orm.SqlCommand.Append(" AND")
+			}
+		}
+		orm.SqlCommand.Append(" ").Append(w)
+	}
+	return nil
+}
+
+func (s *Serve) OrderBy(orm *datatable.ORM, field string) error {
+	orm.SqlCommand.Append(" ORDER BY ").Append(field)
+	return nil
+}
+
+func (s *Serve) GroupBy(orm *datatable.ORM, field string) error {
+	orm.SqlCommand.Append(" GROUP BY ").Append(field)
+	return nil
+}
+
+func (s *Serve) Limit(orm *datatable.ORM, limit int, offset ...int) error {
+	orm.SqlCommand.Append(" LIMIT ").Append(s.bind(orm, limit))
+	if len(offset) > 0 {
+		orm.SqlCommand.Append(" OFFSET ").Append(s.bind(orm, offset[0]))
+	}
+	return nil
+}
+
+// Call SQLite没有存储过程
+// Call fails: SQLite has no stored procedures
+func (s *Serve) Call(orm *datatable.ORM, proc string, params ...sql.NamedArg) error {
+	return errors.New("sqlite does not support stored procedures")
+}
+
+func (s *Serve) DataSet(orm *datatable.ORM) (*datatable.DataSet, error) {
+	return s.dataSet(orm.SqlCommand.String(), orm.SqlValues...)
+}
+
+func (s *Serve) DataTable(orm *datatable.ORM) (*datatable.DataTable, error) {
+	return s.dataTable(orm.SqlCommand.String(), orm.SqlValues...)
+}
+
+func (s *Serve) Execute(orm *datatable.ORM) (sql.Result, error) {
+	return s.exec(orm.SqlCommand.String(), orm.SqlValues...)
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package sqlites
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		typ   string
+		value string
+		want  interface{}
+	}{
+		{"INTEGER", "42", int64(42)},
+		{"BIGINT", "x", "x"},
+		{"VARCHAR(20)", "42", "42"},
+		{"REAL", "1.5", 1.5},
+		{"", "raw", []byte("raw")},
+		{"NUMERIC", "7", int64(7)},
+		{"DECIMAL(10,2)", "7.25", 7.25},
+		{"DATETIME", "2021-01-02 03:04:05", "2021-01-02 03:04:05"},
+		{"BOOLEAN", "1", true},
+	}
+	for _, test := range tests {
+		if v, _ := convert(test.typ, []byte(test.value)); !reflect.DeepEqual(v, test.want) {
+			t.Errorf("%s %s: %#v", test.typ, test.value, v)
+		}
+	}
+}
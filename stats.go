@@ -0,0 +1,58 @@
+// Copyright (c) 2021 BlueStorm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFINGEMENT IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gsql
+
+import (
+	"database/sql"
+	"sync/atomic"
+	"time"
+)
+
+// PoolStats 连接池统计
+// PoolStats reports the state of the ORM pool and the underlying sql.DB
+type PoolStats struct {
+	Size      int           //configured number of handles
+	InUse     int           //handles currently checked out
+	Idle      int           //handles waiting in the pool
+	Waits     int64         //acquisitions that had to wait for a free handle
+	WaitTime  time.Duration //total time spent waiting
+	Timeouts  int64         //acquisitions that gave up waiting
+	Disposals int64         //handles reclaimed by the watchdog
+	DB        sql.DBStats
+}
+
+// Stats 返回连接池统计
+// Stats returns a snapshot of the pool statistics
+func (s *Serve) Stats() PoolStats {
+	stats := PoolStats{
+		Waits:     atomic.LoadInt64(&s.waits),
+		WaitTime:  time.Duration(atomic.LoadInt64(&s.waitTime)),
+		Timeouts:  atomic.LoadInt64(&s.timeouts),
+		Disposals: atomic.LoadInt64(&s.disposals),
+	}
+	if s.pool != nil {
+		stats.Size, stats.InUse, stats.Idle = s.pool.stats()
+	}
+	if s.ISQL != nil {
+		stats.DB = s.ISQL.Stats()
+	}
+	return stats
+}
@@ -223,3 +223,48 @@ func WhetherToSkip(mode int, columns map[string]struct{}, column string) bool {
 	}
 	return false
 }
+
+// Fingerprint 归一化SQL 字面量替换为? 合并空白
+// Fingerprint normalizes a statement so that queries differing only in literal values compare equal
+func Fingerprint(command string) string {
+	builder := Builder{}
+	var space bool
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = builder.Len() > 0
+			continue
+		case c == '\'':
+			for i++; i < len(command); i++ {
+				if command[i] == '\\' {
+					i++
+				} else if command[i] == '\'' {
+					if i+1 < len(command) && command[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			c = '?'
+		case c >= '0' && c <= '9':
+			if prev := builder.Len(); prev > 0 && !space {
+				p := builder.Bytes()[prev-1]
+				if p == '_' || p == '@' || p == '$' || (p >= 'a' && p <= 'z') || (p >= 'A' && p <= 'Z') || (p >= '0' && p <= '9') {
+					break
+				}
+			}
+			for i+1 < len(command) && (command[i+1] == '.' || (command[i+1] >= '0' && command[i+1] <= '9')) {
+				i++
+			}
+			c = '?'
+		}
+		if space {
+			builder.AppendByte(' ')
+			space = false
+		}
+		builder.AppendByte(c)
+	}
+	return builder.ToString()
+}